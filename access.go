@@ -0,0 +1,140 @@
+// MIT+NoAI License
+//
+// Copyright (c) 2023 ugjka <ugjka@proton.me>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights///
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// This code may not be used to train artificial intelligence computer models
+// or retrieved by artificial intelligence software or hardware.
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/huin/goupnp"
+)
+
+// accessList decides who may fetch the streams: the renderers,
+// the allowed networks and this host
+type accessList struct {
+	mu        sync.Mutex
+	renderers map[string]bool
+	nets      []*net.IPNet
+	// this host's addresses
+	local map[string]bool
+	// nothing to restrict to, e.g. in dummy mode without -allow
+	open bool
+}
+
+// newAccessList parses a comma separated list of networks or addresses
+func newAccessList(allow string) (*accessList, error) {
+	a := &accessList{
+		renderers: make(map[string]bool),
+		local:     make(map[string]bool),
+	}
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok {
+			a.local[ipnet.IP.String()] = true
+		}
+	}
+	for _, entry := range strings.Split(allow, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("%s: bad address", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			entry = fmt.Sprintf("%s/%d", ip, bits)
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		a.nets = append(a.nets, network)
+	}
+	return a, nil
+}
+
+// setRenderers allows the devices' addresses, taken from their locations
+func (a *accessList) setRenderers(devices []*goupnp.MaybeRootDevice) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.renderers = make(map[string]bool)
+	for _, dev := range devices {
+		a.renderers[lookupHost(dev.Location.Hostname())] = true
+	}
+}
+
+func (a *accessList) allowed(host string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.open || a.renderers[host] || a.local[host] {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() {
+		return true
+	}
+	for _, network := range a.nets {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// handler rejects and logs the requests from everyone else
+func (a *accessList) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.allowed(remoteIP(r.RemoteAddr)) {
+			log.Printf("access: rejected %s %s %s", r.RemoteAddr, r.Method, r.URL.Path)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newToken returns an unguessable string for the stream paths
+func newToken() (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/huin/goupnp"
+)
+
+func TestAccessList(t *testing.T) {
+	access, err := newAccessList("10.1.0.0/16, 192.0.2.77")
+	if err != nil {
+		t.Fatal(err)
+	}
+	location, _ := url.Parse("http://198.51.100.20:1400/xml/device_description.xml")
+	access.setRenderers([]*goupnp.MaybeRootDevice{{Location: location}})
+	tests := map[string]bool{
+		"198.51.100.20": true,
+		"198.51.100.21": false,
+		"10.1.200.3":    true,
+		"10.2.0.1":      false,
+		"192.0.2.77":    true,
+		"127.0.0.1":     true,
+		"::1":           true,
+	}
+	for host, want := range tests {
+		if got := access.allowed(host); got != want {
+			t.Errorf("%s: allowed %v, wanted %v", host, got, want)
+		}
+	}
+	if _, err := newAccessList("10.0.0.0/33"); err == nil {
+		t.Error("bad network should fail")
+	}
+}
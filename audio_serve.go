@@ -25,6 +25,7 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"log"
@@ -32,14 +33,12 @@ import (
 	"os"
 	"os/exec"
 	"slices"
-	"strings"
 	"sync"
 
 	"github.com/davecgh/go-spew/spew"
 )
 
 type stream struct {
-	sink         string
 	mime         string
 	format       string
 	bitrate      int
@@ -51,6 +50,10 @@ type stream struct {
 	channels     int
 	nochunked    bool
 	be           bool
+	pipe         *pipeline
+	meta         *metadata
+	// url path without the leading slash
+	path string
 }
 
 func (s stream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -77,6 +80,19 @@ func (s stream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	w.Header().Add("Content-Type", s.mime)
 
+	// shoutcast style titles for the clients that ask for them
+	var out io.Writer = w
+	if s.icy() {
+		w.Header().Set("icy-name", ICY_NAME)
+		if s.bitrate != 0 {
+			w.Header().Set("icy-br", fmt.Sprint(s.bitrate))
+		}
+		if r.Header.Get("Icy-MetaData") == "1" {
+			w.Header().Set("icy-metaint", fmt.Sprint(ICY_METAINT))
+			out = newIcyWriter(w, s.meta)
+		}
+	}
+
 	flusher, ok := w.(http.Flusher)
 	chunked := ok && r.Proto == "HTTP/1.1" && !s.nochunked
 
@@ -99,134 +115,157 @@ func (s stream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
-	endianess := "le"
-	if s.be {
-		endianess = "be"
+	host := remoteIP(r.RemoteAddr)
+	run, reader, err := s.pipe.subscribe(s, host)
+	if err != nil {
+		log.Printf("stream: %v", err)
+		return
 	}
-	parecCMD := exec.Command(
-		"parec",
-		"--device="+s.sink,
-		"--client-name=blast-rec",
-		"--rate="+fmt.Sprint(s.samplerate),
-		"--channels="+fmt.Sprint(s.channels),
-		"--format="+fmt.Sprintf("s%d%s", s.bitdepth, endianess),
-		"--raw",
-	)
-
-	var raw bool
-	// wav can't have big endian
-	var pcm = fmt.Sprintf("pcm_s%dle", s.bitdepth)
-	if s.format == "lpcm" || s.format == "wav" {
-		raw = true
+	defer s.pipe.release(run, host)
+
+	if len(run.header) > 0 {
+		_, err = out.Write(run.header)
+		if err != nil {
+			return
+		}
+	}
+	for {
+		chunk, err := reader.chunk()
+		if err != nil {
+			break
+		}
+		_, err = out.Write(chunk)
+		if err != nil {
+			break
+		}
+		if chunked {
+			flusher.Flush()
+		}
 	}
-	if s.format == "lpcm" {
-		// lpcm can have big endian
-		s.format = fmt.Sprintf("s%d%s", s.bitdepth, endianess)
-		pcm = fmt.Sprintf("pcm_s%d%s", s.bitdepth, endianess)
+	if reader.skipped > 0 {
+		log.Printf("%s: client was too slow, skipped %d chunks",
+			r.RemoteAddr, reader.skipped)
 	}
+}
 
-	ffargs := []string{
-		"-f", fmt.Sprintf("s%d%s", s.bitdepth, endianess),
-		"-ac", fmt.Sprint(s.channels),
-		"-ar", fmt.Sprint(s.samplerate),
-		"-i", "-",
-		"-f", s.format, "-",
+// raw reports whether the stream is plain pcm that blast wraps itself
+func (s stream) raw() bool {
+	return s.format == "lpcm" || s.format == "wav"
+}
+
+// startPipeline starts recording and, for the lossy formats, ffmpeg
+// and pumps the encoded audio into a broadcast
+func (s stream) startPipeline(sink string) (*pipelineRun, error) {
+	endianess := "le"
+	// wav can't have big endian
+	if s.be && s.format != "wav" {
+		endianess = "be"
 	}
-	if s.bitrate != 0 {
-		ffargs = slices.Insert(
-			ffargs,
-			len(ffargs)-3,
-			"-b:a", fmt.Sprintf("%dk", s.bitrate),
-		)
+	capt, err := parseCapture(sink)
+	if err != nil {
+		return nil, err
 	}
-	if raw {
-		ffargs = slices.Insert(
-			ffargs,
-			len(ffargs)-1,
-			"-c:a", pcm,
-		)
+	recorder, err := capt.record(sampleSpec{
+		format:   fmt.Sprintf("s%d%s", s.bitdepth, endianess),
+		rate:     s.samplerate,
+		channels: s.channels,
+	})
+	if err != nil {
+		return nil, err
 	}
-	//spew.Dump(strings.Join(ffargs, " "))
-	ffmpegCMD := exec.Command("ffmpeg", ffargs...)
 
-	if *logblast {
-		fmt.Fprintln(os.Stderr, strings.Join(parecCMD.Args, " "))
-		parecCMD.Stderr = os.Stderr
-		fmt.Fprintln(os.Stderr, strings.Join(ffmpegCMD.Args, " "))
-		ffmpegCMD.Stderr = os.Stderr
+	// lpcm and wav are the recorded samples as they are
+	var encoder io.ReadCloser = recorder
+	if !s.raw() {
+		encoder, err = s.startEncoder(recorder, endianess)
+		if err != nil {
+			recorder.Close()
+			return nil, err
+		}
 	}
 
-	parecReader, parecWriter := io.Pipe()
-	parecCMD.Stdout = parecWriter
-	ffmpegCMD.Stdin = parecReader
-
-	ffmpegReader, ffmpegWriter := io.Pipe()
-	ffmpegCMD.Stdout = ffmpegWriter
-
-	var wg sync.WaitGroup
-	//defer fmt.Println("done")
-	defer wg.Wait()
-
-	err := parecCMD.Start()
-	if err != nil {
-		log.Printf("parec failed: %v", err)
-		return
+	var once sync.Once
+	run := &pipelineRun{
+		cast:  newBroadcast(BROADCAST_BACKLOG),
+		ready: make(chan struct{}),
+		done:  make(chan struct{}),
+		stop: func() {
+			once.Do(func() {
+				recorder.Close()
+				encoder.Close()
+			})
+		},
 	}
-	wg.Add(1)
-	go func() {
-		err := parecCMD.Wait()
-		if err != nil && !strings.Contains(err.Error(), "signal") {
-			log.Println("parec:", err)
-		}
-		wg.Done()
-	}()
 
-	err = ffmpegCMD.Start()
-	if err != nil {
-		log.Printf("ffmpeg failed: %v", err)
-		return
+	// raw pcm must be split on frame boundaries
+	// so that skipped clients stay aligned
+	align := 1
+	if s.raw() {
+		align = s.bitdepth / 8 * s.channels
 	}
-	wg.Add(1)
+	bufsize := (s.bitrate / 8) * 1000 * s.chunk
+	if s.bitrate == 0 {
+		bufsize = s.samplerate * s.bitdepth * s.channels * s.chunk
+	}
+	bufsize -= bufsize % align
+
 	go func() {
-		err := ffmpegCMD.Wait()
-		if err != nil && !strings.Contains(err.Error(), "signal") {
-			log.Println("ffmpeg:", err)
+		defer close(run.done)
+		defer run.stop()
+		defer run.cast.Close()
+
+		encoded := bufio.NewReader(encoder)
+		switch s.format {
+		case "wav":
+			run.header = wavHeader(s.bitdepth, s.samplerate, s.channels)
+		case "lpcm":
+		default:
+			run.header, run.err = readStreamHeader(s.format, encoded)
 		}
-		ffmpegWriter.Close()
-		wg.Done()
-	}()
-	if chunked {
-		var (
-			err error
-			n   int
-		)
-		buf := make([]byte, (s.bitrate/8)*1000*s.chunk)
-		if s.bitrate == 0 {
-			buf = make([]byte, s.samplerate*s.bitdepth*s.channels*s.chunk)
+		close(run.ready)
+		if run.err != nil {
+			return
 		}
+		buf := make([]byte, bufsize)
+		var pending int
 		for {
-			n, err = ffmpegReader.Read(buf)
+			n, err := encoded.Read(buf[pending:])
 			if err != nil {
-				break
+				return
 			}
-			_, err = w.Write(buf[:n])
-			if err != nil {
-				break
+			pending += n
+			whole := pending - pending%align
+			if whole == 0 {
+				continue
 			}
-			flusher.Flush()
+			// the broadcast is closed once the run is stopped
+			if _, err := run.cast.Write(buf[:whole]); err != nil {
+				return
+			}
+			pending = copy(buf, buf[whole:pending])
 		}
-	} else {
-		io.Copy(w, ffmpegReader)
-	}
+	}()
+	return run, nil
+}
 
-	if parecCMD.Process != nil {
-		parecCMD.Process.Kill()
+// startEncoder encodes the raw pcm with ffmpeg
+func (s stream) startEncoder(pcm io.Reader, endianess string) (io.ReadCloser, error) {
+	ffargs := []string{
+		"-f", fmt.Sprintf("s%d%s", s.bitdepth, endianess),
+		"-ac", fmt.Sprint(s.channels),
+		"-ar", fmt.Sprint(s.samplerate),
+		"-i", "-",
+		"-f", s.format, "-",
 	}
-	if ffmpegCMD.Process != nil {
-		ffmpegCMD.Process.Kill()
+	if s.bitrate != 0 {
+		ffargs = slices.Insert(
+			ffargs,
+			len(ffargs)-3,
+			"-b:a", fmt.Sprintf("%dk", s.bitrate),
+		)
 	}
-	parecReader.Close()
-	parecWriter.Close()
-	ffmpegReader.Close()
-	ffmpegWriter.Close()
+	//spew.Dump(strings.Join(ffargs, " "))
+	ffmpegCMD := exec.Command("ffmpeg", ffargs...)
+	ffmpegCMD.Stdin = pcm
+	return startCommand(ffmpegCMD)
 }
@@ -25,28 +25,24 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
-	"os/exec"
 )
 
 func chooseAudioSource(lookup string) (string, error) {
-	srcCMD := exec.Command("pactl", "-f", "json", "list", "sources", "short")
-	srcData, err := srcCMD.Output()
+	capt, err := parseCapture(lookup)
 	if err != nil {
-		return "", fmt.Errorf("pactl sources: %v", err)
+		return "", err
 	}
-
-	var srcJSON Sources
-	err = json.Unmarshal(srcData, &srcJSON)
+	pc, ok := capt.(pulseCapture)
+	if !ok {
+		// not a pulse source, nothing to look up
+		return lookup, nil
+	}
+	lookup = pc.source
+	srcJSON, err := listAudioSources()
 	if err != nil {
 		return "", err
 	}
-	if len(srcJSON) == 0 {
-		return "", fmt.Errorf("no audio sources found")
-	}
-	// append for on-demand loading of blast sink
-	srcJSON = append(srcJSON, struct{ Name string }{BLASTMONITOR})
 	if lookup != "" {
 		for _, v := range srcJSON {
 			if v.Name == lookup {
@@ -68,6 +64,27 @@ func chooseAudioSource(lookup string) (string, error) {
 	return srcJSON[selected].Name, nil
 }
 
-type Sources []struct {
-	Name string
+func listAudioSources() (Sources, error) {
+	srcJSON, err := pulse.listSources()
+	if err != nil {
+		return nil, err
+	}
+	if len(srcJSON) == 0 {
+		return nil, fmt.Errorf("no audio sources found")
+	}
+	// append for on-demand loading of blast sink
+	srcJSON = append(srcJSON, Source{Name: BLASTMONITOR})
+	return srcJSON, nil
+}
+
+type Sources []Source
+
+type Source struct {
+	Index      int    `json:"index"`
+	Name       string `json:"name"`
+	Driver     string `json:"driver,omitempty"`
+	SampleSpec string `json:"sample_specification,omitempty"`
+	State      string `json:"state,omitempty"`
+	// the index of the sink it monitors, native backend only
+	monitorOf uint32
 }
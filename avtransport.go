@@ -26,6 +26,7 @@ package main
 
 import (
 	"fmt"
+	"html"
 	"log"
 	"strings"
 	"time"
@@ -39,12 +40,17 @@ type avsetup struct {
 	stream    stream
 	logoURI   string
 	streamURI string
+	// shown in the renderer's now playing screen
+	now nowPlaying
 }
 
 type avtransport interface {
 	SetAVTransportURI(InstanceID uint32, CurrentURI string, CurrentURIMetaData string) (err error)
+	SetNextAVTransportURI(InstanceID uint32, NextURI string, NextURIMetaData string) (err error)
 	Play(InstanceID uint32, Speed string) (err error)
 	Stop(InstanceID uint32) (err error)
+	GetTransportInfo(InstanceID uint32) (CurrentTransportState string, CurrentTransportStatus string, CurrentSpeed string, err error)
+	GetMediaInfo(InstanceID uint32) (NrTracks uint32, MediaDuration string, CurrentURI string, CurrentURIMetaData string, NextURI string, NextURIMetaData string, PlayMedium string, RecordMedium string, WriteStatus string, err error)
 }
 
 func detectAVtransport(dev *goupnp.MaybeRootDevice) string {
@@ -59,28 +65,32 @@ func detectAVtransport(dev *goupnp.MaybeRootDevice) string {
 	return ""
 }
 
-func AVSetAndPlay(av avsetup) error {
-	urn := detectAVtransport(av.device)
-	var client avtransport
+func newAVTransportClient(dev *goupnp.MaybeRootDevice) (avtransport, error) {
+	urn := detectAVtransport(dev)
 
 	switch {
 	case urn == av1.URN_AVTransport_1:
-		clients, err := av1.NewAVTransport1ClientsByURL(av.device.Location)
+		clients, err := av1.NewAVTransport1ClientsByURL(dev.Location)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		client = avtransport(clients[0])
+		return avtransport(clients[0]), nil
 	case urn == av1.URN_AVTransport_2:
-		clients, err := av1.NewAVTransport2ClientsByURL(av.device.Location)
+		clients, err := av1.NewAVTransport2ClientsByURL(dev.Location)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		client = avtransport(clients[0])
-	default:
-		return fmt.Errorf("no avtransport found")
+		return avtransport(clients[0]), nil
+	}
+	return nil, fmt.Errorf("no avtransport found")
+}
+
+func AVSetAndPlay(av avsetup) error {
+	client, err := newAVTransportClient(av.device)
+	if err != nil {
+		return err
 	}
 
-	var err error
 	try := func(metadata string) error {
 		err = client.SetAVTransportURI(0, av.streamURI, metadata)
 		if err != nil {
@@ -94,8 +104,36 @@ func AVSetAndPlay(av avsetup) error {
 		return nil
 	}
 
+	err = try(av.didl())
+	if err == nil {
+		return nil
+	}
+	log.Println(err)
+	log.Println("trying without metadata")
+	return try("")
+}
+
+// AVSetMetadata sends the now playing info of the stream that is
+// already playing, as the next uri or, with reset, by setting the uri again
+func AVSetMetadata(av avsetup, reset bool) error {
+	if reset {
+		return AVSetAndPlay(av)
+	}
+	client, err := newAVTransportClient(av.device)
+	if err != nil {
+		return err
+	}
+	return client.SetNextAVTransportURI(0, av.streamURI, av.didl())
+}
+
+// didl returns the DIDL-Lite metadata of the stream
+func (av avsetup) didl() string {
+	title, artist := av.now.didl()
 	metadata := fmt.Sprintf(
 		didlTemplate,
+		html.EscapeString(title),
+		html.EscapeString(artist),
+		html.EscapeString(artist),
 		av.logoURI,
 		av.stream.mime,
 		av.stream.contentfeat,
@@ -105,38 +143,14 @@ func AVSetAndPlay(av avsetup) error {
 		av.streamURI,
 	)
 	metadata = strings.ReplaceAll(metadata, "\n", " ")
-	metadata = strings.ReplaceAll(metadata, "> <", "><")
-
-	err = try(metadata)
-	if err == nil {
-		return nil
-	}
-	log.Println(err)
-	log.Println("trying without metadata")
-	return try("")
+	return strings.ReplaceAll(metadata, "> <", "><")
 }
 
 func AVStop(device *goupnp.MaybeRootDevice) {
-	urn := detectAVtransport(device)
-	var client avtransport
-
-	switch {
-	case urn == av1.URN_AVTransport_1:
-		clients, err := av1.NewAVTransport1ClientsByURL(device.Location)
-		if err != nil {
-			return
-		}
-		client = avtransport(clients[0])
-	case urn == av1.URN_AVTransport_2:
-		clients, err := av1.NewAVTransport2ClientsByURL(device.Location)
-		if err != nil {
-			return
-		}
-		client = avtransport(clients[0])
-	default:
+	client, err := newAVTransportClient(device)
+	if err != nil {
 		return
 	}
-
 	client.Stop(0)
 }
 
@@ -149,9 +163,9 @@ xmlns:sec="http://www.sec.co.kr/"
 xmlns:pv="http://www.pv.com/pvns/">
 <item id="0" parentID="-1" restricted="1">
 <upnp:class>object.item.audioItem.musicTrack</upnp:class>
-<dc:title>Audio Cast</dc:title>
-<dc:creator>Blast</dc:creator>
-<upnp:artist>Blast</upnp:artist>
+<dc:title>%s</dc:title>
+<dc:creator>%s</dc:creator>
+<upnp:artist>%s</upnp:artist>
 <upnp:albumArtURI>%s</upnp:albumArtURI>
 <res protocolInfo="http-get:*:%s:%s"
 bitsPerSample="%d"
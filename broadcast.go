@@ -0,0 +1,413 @@
+// MIT+NoAI License
+//
+// Copyright (c) 2023 ugjka <ugjka@proton.me>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights///
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// This code may not be used to train artificial intelligence computer models
+// or retrieved by artificial intelligence software or hardware.
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+)
+
+// how many encoded chunks are kept around for clients that fall behind
+const BROADCAST_BACKLOG = 64
+
+// broadcast fans out the encoded audio to every connected client.
+// Writers never block, clients that can't keep up are skipped ahead.
+type broadcast struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	chunks [][]byte
+	// sequence number of the next chunk to be written
+	seq    uint64
+	closed bool
+}
+
+func newBroadcast(backlog int) *broadcast {
+	b := &broadcast{
+		chunks: make([][]byte, backlog),
+	}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+func (b *broadcast) Write(p []byte) (int, error) {
+	chunk := make([]byte, len(p))
+	copy(chunk, p)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, io.ErrClosedPipe
+	}
+	b.chunks[b.seq%uint64(len(b.chunks))] = chunk
+	b.seq++
+	b.cond.Broadcast()
+	return len(p), nil
+}
+
+func (b *broadcast) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.cond.Broadcast()
+	return nil
+}
+
+// reader returns a reader that starts at the live edge of the broadcast
+func (b *broadcast) reader() *broadcastReader {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return &broadcastReader{b: b, next: b.seq}
+}
+
+type broadcastReader struct {
+	b    *broadcast
+	next uint64
+	// how many chunks were skipped because the reader was too slow
+	skipped uint64
+}
+
+// chunk blocks until the next chunk is available
+func (r *broadcastReader) chunk() ([]byte, error) {
+	b := r.b
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for r.next == b.seq && !b.closed {
+		b.cond.Wait()
+	}
+	if r.next == b.seq && b.closed {
+		return nil, io.EOF
+	}
+	oldest := uint64(0)
+	if b.seq > uint64(len(b.chunks)) {
+		oldest = b.seq - uint64(len(b.chunks))
+	}
+	if r.next < oldest {
+		// too slow, jump to the live edge
+		r.skipped += b.seq - 1 - r.next
+		r.next = b.seq - 1
+	}
+	chunk := b.chunks[r.next%uint64(len(b.chunks))]
+	r.next++
+	return chunk, nil
+}
+
+// readStreamHeader reads the container header that every client needs
+// before it can decode the stream, e.g. the wav RIFF header or the
+// flac metadata blocks. Formats that can be joined at any point have
+// no header.
+func readStreamHeader(format string, r *bufio.Reader) ([]byte, error) {
+	switch format {
+	case "wav":
+		return readWAVHeader(r)
+	case "flac":
+		return readFLACHeader(r)
+	case "ogg", "oga", "opus", "spx":
+		return readOggHeader(r)
+	case "caf":
+		return readCAFHeader(r)
+	}
+	return nil, nil
+}
+
+// sharedFormats can be joined by a client at any point, with the header
+// from readStreamHeader. The ffmpeg muxers blast doesn't know about get
+// a pipeline for each client, their header isn't kept.
+var sharedFormats = []string{
+	"lpcm", "wav", "flac", "ogg", "oga", "opus", "spx", "caf",
+	"mp3", "adts", "ac3", "eac3", "mp2", "mpegts",
+}
+
+func readWAVHeader(r *bufio.Reader) ([]byte, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if string(header[:4]) != "RIFF" && string(header[:4]) != "RF64" ||
+		string(header[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("wav: bad header")
+	}
+	// copy chunks until we reach the data chunk
+	for {
+		chunk := make([]byte, 8)
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return nil, err
+		}
+		header = append(header, chunk...)
+		if string(chunk[:4]) == "data" {
+			return header, nil
+		}
+		size := binary.LittleEndian.Uint32(chunk[4:])
+		// chunks are word aligned
+		size += size & 1
+		body := make([]byte, size)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return nil, err
+		}
+		header = append(header, body...)
+	}
+}
+
+func readFLACHeader(r *bufio.Reader) ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if string(header) != "fLaC" {
+		return nil, fmt.Errorf("flac: bad header")
+	}
+	for {
+		block := make([]byte, 4)
+		if _, err := io.ReadFull(r, block); err != nil {
+			return nil, err
+		}
+		last := block[0]&0x80 != 0
+		size := int(block[1])<<16 | int(block[2])<<8 | int(block[3])
+		body := make([]byte, size)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return nil, err
+		}
+		header = append(header, block...)
+		header = append(header, body...)
+		if last {
+			return header, nil
+		}
+	}
+}
+
+// readOggHeader reads the pages with the codec headers, e.g. OpusHead
+// and OpusTags. Their granule position is 0, or -1 for a page that
+// doesn't end a packet, and the audio starts on a fresh page.
+func readOggHeader(r *bufio.Reader) ([]byte, error) {
+	var header []byte
+	for {
+		page, err := r.Peek(27)
+		if err != nil {
+			return nil, err
+		}
+		if string(page[:4]) != "OggS" {
+			return nil, fmt.Errorf("ogg: bad page")
+		}
+		granule := binary.LittleEndian.Uint64(page[6:14])
+		if len(header) > 0 && granule != 0 && granule != ^uint64(0) {
+			return header, nil
+		}
+		segments := int(page[26])
+		page, err = r.Peek(27 + segments)
+		if err != nil {
+			return nil, err
+		}
+		size := 27 + segments
+		for _, lacing := range page[27:] {
+			size += int(lacing)
+		}
+		body := make([]byte, size)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return nil, err
+		}
+		header = append(header, body...)
+	}
+}
+
+func readCAFHeader(r *bufio.Reader) ([]byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if string(header[:4]) != "caff" {
+		return nil, fmt.Errorf("caf: bad header")
+	}
+	// copy chunks until we reach the data chunk
+	for {
+		chunk := make([]byte, 12)
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return nil, err
+		}
+		header = append(header, chunk...)
+		if string(chunk[:4]) == "data" {
+			// the edit count comes before the audio
+			edits := make([]byte, 4)
+			if _, err := io.ReadFull(r, edits); err != nil {
+				return nil, err
+			}
+			return append(header, edits...), nil
+		}
+		size := int64(binary.BigEndian.Uint64(chunk[4:]))
+		if size < 0 {
+			return nil, fmt.Errorf("caf: bad chunk size")
+		}
+		body := make([]byte, size)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return nil, err
+		}
+		header = append(header, body...)
+	}
+}
+
+// pipeline runs a single capture/encode process pair per stream format
+// and shares its output between all the clients. It is started with the
+// first client and stopped once the last one is gone. Formats that can't
+// be joined midway get a pipeline for each client.
+type pipeline struct {
+	mu sync.Mutex
+	// the audio source to capture
+	sink    string
+	clients int
+	// connected clients by their ip address
+	hosts map[string]int
+	// stream requests by ip address
+	requests map[string]int
+	run      *pipelineRun
+	// the runs of the clients that can't share one
+	own map[*pipelineRun]bool
+}
+
+type pipelineRun struct {
+	cast *broadcast
+	// closed once the header is read or the run failed
+	ready  chan struct{}
+	header []byte
+	err    error
+	// closed once the encoder output has ended
+	done chan struct{}
+	stop func()
+}
+
+// subscribe returns a reader for the running pipeline,
+// starting it if necessary. Call release when done.
+func (p *pipeline) subscribe(s stream, host string) (*pipelineRun, *broadcastReader, error) {
+	p.mu.Lock()
+	if p.run != nil {
+		select {
+		case <-p.run.done:
+			// the encoder died, start over
+			p.run = nil
+		default:
+		}
+	}
+	var run *pipelineRun
+	switch {
+	case !slices.Contains(sharedFormats, s.format):
+		own, err := s.startPipeline(p.sink)
+		if err != nil {
+			p.mu.Unlock()
+			return nil, nil, err
+		}
+		if p.own == nil {
+			p.own = make(map[*pipelineRun]bool)
+		}
+		p.own[own] = true
+		run = own
+	case p.run == nil:
+		shared, err := s.startPipeline(p.sink)
+		if err != nil {
+			p.mu.Unlock()
+			return nil, nil, err
+		}
+		p.run = shared
+		run = shared
+	default:
+		run = p.run
+	}
+	p.clients++
+	if p.hosts == nil {
+		p.hosts = make(map[string]int)
+		p.requests = make(map[string]int)
+	}
+	p.hosts[host]++
+	p.requests[host]++
+	p.mu.Unlock()
+
+	<-run.ready
+	if run.err != nil {
+		p.release(run, host)
+		return nil, nil, run.err
+	}
+	return run, run.cast.reader(), nil
+}
+
+func (p *pipeline) release(run *pipelineRun, host string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clients--
+	p.hosts[host]--
+	if p.hosts[host] == 0 {
+		delete(p.hosts, host)
+	}
+	if p.own[run] {
+		run.stop()
+		delete(p.own, run)
+	}
+	if p.clients == 0 && p.run == run {
+		run.stop()
+		p.run = nil
+	}
+}
+
+// setSink switches the audio source, the running
+// clients are disconnected
+func (p *pipeline) setSink(sink string) {
+	p.mu.Lock()
+	p.sink = sink
+	p.mu.Unlock()
+	p.stop()
+}
+
+// stop ends the running capture and encoder, the clients are disconnected
+func (p *pipeline) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.run != nil {
+		p.run.stop()
+		p.run = nil
+	}
+	for run := range p.own {
+		run.stop()
+		delete(p.own, run)
+	}
+}
+
+// connected returns the number of clients currently being served
+func (p *pipeline) connected() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.clients
+}
+
+// connectedFrom returns the number of clients served to the given ip address
+func (p *pipeline) connectedFrom(host string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.hosts[host]
+}
+
+// requestsFrom returns how many times the given ip address requested the stream
+func (p *pipeline) requestsFrom(host string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.requests[host]
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+func TestBroadcastSkipsSlowReader(t *testing.T) {
+	b := newBroadcast(4)
+	fast := b.reader()
+	slow := b.reader()
+	for i := 0; i < 10; i++ {
+		b.Write([]byte{byte(i)})
+		chunk, err := fast.chunk()
+		if err != nil || chunk[0] != byte(i) {
+			t.Fatalf("fast reader got %v %v, wanted %d", chunk, err, i)
+		}
+	}
+	chunk, err := slow.chunk()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chunk[0] != 9 {
+		t.Fatalf("slow reader got %d, wanted to skip to 9", chunk[0])
+	}
+	if slow.skipped != 9 {
+		t.Fatalf("skipped %d, wanted 9", slow.skipped)
+	}
+	b.Close()
+	if _, err := slow.chunk(); err != io.EOF {
+		t.Fatalf("got %v, wanted EOF", err)
+	}
+}
+
+func TestStreamHeaders(t *testing.T) {
+	var wav bytes.Buffer
+	wav.WriteString("RIFF\xff\xff\xff\xffWAVE")
+	wav.WriteString("fmt ")
+	binary.Write(&wav, binary.LittleEndian, uint32(16))
+	wav.Write(make([]byte, 16))
+	wav.WriteString("LIST")
+	binary.Write(&wav, binary.LittleEndian, uint32(3))
+	wav.Write(make([]byte, 4))
+	wav.WriteString("data\xff\xff\xff\xff")
+	wantLen := wav.Len()
+	wav.WriteString("samples")
+
+	var flac bytes.Buffer
+	flac.WriteString("fLaC")
+	flac.Write([]byte{0x00, 0, 0, 34})
+	flac.Write(make([]byte, 34))
+	flac.Write([]byte{0x84, 0, 0, 2})
+	flac.Write(make([]byte, 2))
+	flacLen := flac.Len()
+	flac.WriteString("\xff\xf8frames")
+
+	var ogg bytes.Buffer
+	page := func(granule uint64, packet string) {
+		ogg.WriteString("OggS\x00\x00")
+		binary.Write(&ogg, binary.LittleEndian, granule)
+		ogg.Write(make([]byte, 12))
+		ogg.Write([]byte{1, byte(len(packet))})
+		ogg.WriteString(packet)
+	}
+	page(0, "OpusHead")
+	page(0, "OpusTags")
+	oggLen := ogg.Len()
+	page(960, "audio")
+
+	var caf bytes.Buffer
+	caf.WriteString("caff\x00\x01\x00\x00")
+	caf.WriteString("desc")
+	binary.Write(&caf, binary.BigEndian, uint64(32))
+	caf.Write(make([]byte, 32))
+	caf.WriteString("data\xff\xff\xff\xff\xff\xff\xff\xff")
+	caf.Write(make([]byte, 4))
+	cafLen := caf.Len()
+	caf.WriteString("samples")
+
+	tests := []struct {
+		format string
+		data   []byte
+		want   int
+	}{
+		{"wav", wav.Bytes(), wantLen},
+		{"flac", flac.Bytes(), flacLen},
+		{"opus", ogg.Bytes(), oggLen},
+		{"caf", caf.Bytes(), cafLen},
+		{"mp3", []byte("\xff\xfbframes"), 0},
+	}
+	for _, test := range tests {
+		r := bufio.NewReader(bytes.NewReader(test.data))
+		header, err := readStreamHeader(test.format, r)
+		if err != nil {
+			t.Fatalf("%s: %v", test.format, err)
+		}
+		if len(header) != test.want {
+			t.Fatalf("%s: got %d header bytes, wanted %d",
+				test.format, len(header), test.want)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+// MIT+NoAI License
+//
+// Copyright (c) 2023 ugjka <ugjka@proton.me>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights///
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// This code may not be used to train artificial intelligence computer models
+// or retrieved by artificial intelligence software or hardware.
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// capture produces raw pcm audio in the requested sample spec
+type capture interface {
+	record(spec sampleSpec) (io.ReadCloser, error)
+}
+
+// parseCapture picks the capture backend for a source, e.g.
+//
+//	alsa_output.pci-0000_00_1b.0.analog-stereo.monitor
+//	pulse:blast.monitor
+//	alsa:hw:1,0
+//	fifo:/tmp/mpd.fifo
+//	file:/home/user/song.flac
+//	stdin
+func parseCapture(source string) (capture, error) {
+	scheme, path, found := strings.Cut(source, ":")
+	if !found || !isCaptureScheme(scheme) {
+		if source == "stdin" || source == "-" {
+			return stdinCapture{}, nil
+		}
+		return pulseCapture{source}, nil
+	}
+	switch scheme {
+	case "pulse":
+		return pulseCapture{path}, nil
+	case "alsa":
+		return alsaCapture{path}, nil
+	case "fifo":
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if info.Mode()&os.ModeNamedPipe == 0 {
+			return nil, fmt.Errorf("%s: not a fifo", path)
+		}
+		return fifoCapture{path}, nil
+	case "file":
+		if _, err := os.Stat(path); err != nil {
+			return nil, err
+		}
+		return fileCapture{path}, nil
+	case "stdin":
+		return stdinCapture{}, nil
+	}
+	return nil, fmt.Errorf("%s: unknown source", source)
+}
+
+func isCaptureScheme(scheme string) bool {
+	switch scheme {
+	case "pulse", "alsa", "fifo", "file", "stdin":
+		return true
+	}
+	return false
+}
+
+// captureDeps returns the executables the source needs
+func captureDeps(source string) []string {
+	c, err := parseCapture(source)
+	if err != nil {
+		return nil
+	}
+	switch c.(type) {
+	case pulseCapture:
+		if _, ok := pulse.(pulseExec); ok {
+			return []string{"pactl", "parec"}
+		}
+	case alsaCapture:
+		return []string{"arecord"}
+	case fileCapture:
+		return []string{"ffmpeg"}
+	}
+	return nil
+}
+
+// pulseCapture records a pulseaudio source
+type pulseCapture struct {
+	source string
+}
+
+func (c pulseCapture) record(spec sampleSpec) (io.ReadCloser, error) {
+	return pulse.record(c.source, spec)
+}
+
+// alsaCapture records an alsa device with arecord
+type alsaCapture struct {
+	device string
+}
+
+func (c alsaCapture) record(spec sampleSpec) (io.ReadCloser, error) {
+	formats := map[string]string{
+		"s16le": "S16_LE",
+		"s16be": "S16_BE",
+		"s24le": "S24_3LE",
+		"s24be": "S24_3BE",
+		"s32le": "S32_LE",
+		"s32be": "S32_BE",
+	}
+	format, ok := formats[spec.format]
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported sample format", spec.format)
+	}
+	arecordCMD := exec.Command(
+		"arecord",
+		"-q",
+		"-D", c.device,
+		"-f", format,
+		"-r", fmt.Sprint(spec.rate),
+		"-c", fmt.Sprint(spec.channels),
+		"-t", "raw",
+	)
+	return startCommand(arecordCMD)
+}
+
+// fifoCapture reads raw little-endian pcm from a named pipe,
+// e.g. mpd's or snapcast's fifo output
+type fifoCapture struct {
+	path string
+}
+
+func (c fifoCapture) record(spec sampleSpec) (io.ReadCloser, error) {
+	// read-write so that opening doesn't block and
+	// reads don't hit EOF while the writer is away
+	fifo, err := os.OpenFile(c.path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	return toByteOrder(fifo, spec), nil
+}
+
+// stdinCapture reads raw little-endian pcm from the standard input
+type stdinCapture struct{}
+
+func (stdinCapture) record(spec sampleSpec) (io.ReadCloser, error) {
+	return toByteOrder(stdin.reader(spec), spec), nil
+}
+
+// the standard input can only be read once,
+// so it is shared by the pipeline runs
+var stdin = &sharedInput{in: os.Stdin}
+
+// sharedInput reads its input for as long as it lasts and hands it
+// to the latest reader, nothing is kept while there is no reader
+type sharedInput struct {
+	in   io.Reader
+	once sync.Once
+	mu   sync.Mutex
+	cur  *sharedReader
+	// bytes read so far, to start the readers on a frame boundary
+	offset int
+	// closed when the input has ended
+	done chan struct{}
+	err  error
+}
+
+// reader returns a reader of the input from now on,
+// the previous reader gets nothing more
+func (s *sharedInput) reader(spec sampleSpec) io.ReadCloser {
+	s.once.Do(func() {
+		s.done = make(chan struct{})
+		go s.pump()
+	})
+	frame := sampleSize(spec.format) * spec.channels
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := &sharedReader{
+		src:    s,
+		chunks: make(chan []byte),
+		closed: make(chan struct{}),
+		skip:   (frame - s.offset%frame) % frame,
+	}
+	s.cur = r
+	return r
+}
+
+func (s *sharedInput) pump() {
+	for {
+		buf := make([]byte, 32*1024)
+		n, err := s.in.Read(buf)
+		s.mu.Lock()
+		r := s.cur
+		s.offset += n
+		s.mu.Unlock()
+		if n > 0 && r != nil {
+			select {
+			case r.chunks <- buf[:n]:
+			case <-r.closed:
+			}
+		}
+		if err != nil {
+			s.err = err
+			close(s.done)
+			return
+		}
+	}
+}
+
+type sharedReader struct {
+	src     *sharedInput
+	chunks  chan []byte
+	pending []byte
+	// bytes to drop to reach a frame boundary
+	skip   int
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (r *sharedReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		select {
+		case chunk := <-r.chunks:
+			drop := r.skip
+			if drop > len(chunk) {
+				drop = len(chunk)
+			}
+			r.skip -= drop
+			r.pending = chunk[drop:]
+		case <-r.closed:
+			return 0, io.ErrClosedPipe
+		case <-r.src.done:
+			return 0, r.src.err
+		}
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func (r *sharedReader) Close() error {
+	r.once.Do(func() {
+		r.src.mu.Lock()
+		if r.src.cur == r {
+			r.src.cur = nil
+		}
+		r.src.mu.Unlock()
+		close(r.closed)
+	})
+	return nil
+}
+
+// fileCapture decodes a local audio file with ffmpeg and plays it in a loop
+type fileCapture struct {
+	path string
+}
+
+func (c fileCapture) record(spec sampleSpec) (io.ReadCloser, error) {
+	decodeCMD := exec.Command(
+		"ffmpeg",
+		"-loglevel", "error",
+		"-re",
+		"-stream_loop", "-1",
+		"-i", c.path,
+		"-vn",
+		"-f", spec.format,
+		"-ar", fmt.Sprint(spec.rate),
+		"-ac", fmt.Sprint(spec.channels),
+		"-",
+	)
+	return startCommand(decodeCMD)
+}
+
+// startCommand starts a command and returns its standard output
+func startCommand(cmd *exec.Cmd) (io.ReadCloser, error) {
+	if *logblast {
+		fmt.Fprintln(os.Stderr, strings.Join(cmd.Args, " "))
+		cmd.Stderr = os.Stderr
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %v", cmd.Args[0], err)
+	}
+	return &execReader{ReadCloser: stdout, cmd: cmd}, nil
+}
+
+// toByteOrder swaps little-endian samples when big-endian is wanted
+func toByteOrder(r io.ReadCloser, spec sampleSpec) io.ReadCloser {
+	if !strings.HasSuffix(spec.format, "be") {
+		return r
+	}
+	return &swapReader{ReadCloser: r, size: sampleSize(spec.format)}
+}
+
+type swapReader struct {
+	io.ReadCloser
+	size int
+	// a partial sample left over from the last read
+	pending []byte
+}
+
+func (r *swapReader) Read(p []byte) (int, error) {
+	if len(p) < r.size {
+		return 0, io.ErrShortBuffer
+	}
+	copy(p, r.pending)
+	n, err := r.ReadCloser.Read(p[len(r.pending):])
+	n += len(r.pending)
+	whole := n - n%r.size
+	r.pending = append(r.pending[:0], p[whole:n]...)
+	for i := 0; i < whole; i += r.size {
+		sample := p[i : i+r.size]
+		for a, b := 0, r.size-1; a < b; a, b = a+1, b-1 {
+			sample[a], sample[b] = sample[b], sample[a]
+		}
+	}
+	return whole, err
+}
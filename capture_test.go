@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestSwapReader(t *testing.T) {
+	le := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+	// one byte at a time splits the samples across reads
+	r := toByteOrder(io.NopCloser(iotest.OneByteReader(bytes.NewReader(le))), sampleSpec{format: "s24be"})
+	be, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{3, 2, 1, 6, 5, 4, 9, 8, 7, 12, 11, 10}
+	if !bytes.Equal(be, want) {
+		t.Fatalf("got %v, wanted %v", be, want)
+	}
+}
+
+func TestParseCapture(t *testing.T) {
+	tests := map[string]capture{
+		"alsa_output.pci.analog-stereo.monitor": pulseCapture{"alsa_output.pci.analog-stereo.monitor"},
+		"pulse:blast.monitor":                   pulseCapture{"blast.monitor"},
+		"alsa:hw:1,0":                           alsaCapture{"hw:1,0"},
+		"stdin":                                 stdinCapture{},
+	}
+	for source, want := range tests {
+		got, err := parseCapture(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%s: got %#v, wanted %#v", source, got, want)
+		}
+	}
+	if _, err := parseCapture("fifo:/nonexistent"); err == nil {
+		t.Error("missing fifo should fail")
+	}
+}
+
+func TestSharedInput(t *testing.T) {
+	in, out := io.Pipe()
+	src := &sharedInput{in: in}
+	spec := sampleSpec{format: "s16le", channels: 2}
+
+	first := src.reader(spec)
+	go out.Write([]byte{1, 2, 3, 4, 5, 6})
+	buf := make([]byte, 6)
+	if _, err := io.ReadFull(first, buf); err != nil {
+		t.Fatal(err)
+	}
+	first.Close()
+
+	// the next reader starts on a frame boundary
+	second := src.reader(spec)
+	go func() {
+		out.Write([]byte{7, 8, 9, 10, 11, 12})
+		out.Close()
+	}()
+	got, err := io.ReadAll(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, []byte{9, 10, 11, 12}) {
+		t.Fatalf("got %v", got)
+	}
+	if _, err := first.Read(buf); err == nil {
+		t.Fatal("the closed reader still reads")
+	}
+}
@@ -0,0 +1,206 @@
+// MIT+NoAI License
+//
+// Copyright (c) 2023 ugjka <ugjka@proton.me>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights///
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// This code may not be used to train artificial intelligence computer models
+// or retrieved by artificial intelligence software or hardware.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/huin/goupnp"
+)
+
+// the flags a device profile may set
+var profileFlags = []string{
+	"auto",
+	"bitrate",
+	"bits",
+	"channels",
+	"chunk",
+	"format",
+	"formats",
+	"mime",
+	"nochunked",
+	"protocol",
+	"rate",
+	"useaac",
+	"useflac",
+	"uselpcm",
+	"uselpcmle",
+	"usewav",
+	"volume",
+}
+
+// config holds flag defaults and per-device profiles, e.g.
+//
+//	source = "blast.monitor"
+//	bitrate = 256
+//
+//	[profiles."Samsung TV"]
+//	nochunked = true
+//	formats = "wav"
+//
+//	[profiles."uuid:RINCON_000E58000000001400"]
+//	formats = "mp3"
+//	protocol = "x-rincon-mp3radio"
+//	volume = 20
+type config struct {
+	defaults map[string]any
+	profiles map[string]map[string]any
+}
+
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "blast", "config.toml")
+}
+
+// loadConfig reads the config file, a missing file is
+// an empty config unless it was explicitly asked for
+func loadConfig(path string, explicit bool) (*config, error) {
+	c := &config{
+		defaults: make(map[string]any),
+		profiles: make(map[string]map[string]any),
+	}
+	if path == "" {
+		return c, nil
+	}
+	var raw map[string]any
+	_, err := toml.DecodeFile(path, &raw)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range raw {
+		if key != "profiles" {
+			c.defaults[key] = value
+			continue
+		}
+		profiles, ok := value.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("profiles: not a table")
+		}
+		for name, profile := range profiles {
+			options, ok := profile.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("profiles.%s: not a table", name)
+			}
+			for key := range options {
+				if !isProfileFlag(key) {
+					return nil, fmt.Errorf("profiles.%s: %s can't be set per device", name, key)
+				}
+			}
+			c.profiles[name] = options
+		}
+	}
+	return c, nil
+}
+
+func isProfileFlag(name string) bool {
+	for _, f := range profileFlags {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// applyDefaults sets the flags that weren't given on the command line
+func (c *config) applyDefaults(flags *flag.FlagSet, given map[string]bool) error {
+	return setFlags(flags, given, c.defaults, "config")
+}
+
+// profile returns the profile for the device
+// matched by its friendly name or UDN
+func (c *config) profile(dev *goupnp.MaybeRootDevice) (string, map[string]any, bool) {
+	if dev.Root == nil {
+		return "", nil, false
+	}
+	for name, options := range c.profiles {
+		if name == dev.Root.Device.FriendlyName ||
+			strings.EqualFold(name, dev.Root.Device.UDN) {
+			return name, options, true
+		}
+	}
+	return "", nil, false
+}
+
+// presets are the use* flags, only one of them takes effect
+var presets = []string{"useaac", "useflac", "uselpcm", "uselpcmle", "usewav"}
+
+// setFlags sets the options that weren't given on the command line.
+// A preset turned on replaces the one set before, unless the
+// command line picked one.
+func setFlags(flags *flag.FlagSet, given map[string]bool, options map[string]any, where string) error {
+	picked, chosen := false, false
+	for _, name := range presets {
+		if f := flags.Lookup(name); f != nil && given[name] {
+			picked = picked || f.Value.String() == "true"
+		}
+		chosen = chosen || fmt.Sprint(options[name]) == "true"
+	}
+	if chosen && !picked {
+		replaced := make(map[string]any, len(options)+len(presets))
+		for key, value := range options {
+			replaced[key] = value
+		}
+		for _, name := range presets {
+			if _, ok := replaced[name]; !ok && flags.Lookup(name) != nil {
+				replaced[name] = false
+			}
+		}
+		options = replaced
+	}
+	// sorted for deterministic errors
+	keys := make([]string, 0, len(options))
+	for key := range options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if given[key] || picked && slices.Contains(presets, key) {
+			continue
+		}
+		if flags.Lookup(key) == nil {
+			return fmt.Errorf("%s: unknown option %s", where, key)
+		}
+		err := flags.Set(key, fmt.Sprint(options[key]))
+		if err != nil {
+			return fmt.Errorf("%s: %s: %v", where, key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/huin/goupnp"
+)
+
+func TestConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	err := os.WriteFile(path, []byte(`
+bitrate = 256
+source = "blast.monitor"
+
+[profiles."Samsung TV"]
+nochunked = true
+formats = "wav"
+
+[profiles."uuid:RINCON_1400"]
+protocol = "x-rincon-mp3radio"
+volume = 20
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadConfig(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flags := flag.NewFlagSet("blast", flag.ContinueOnError)
+	bitrate := flags.Int("bitrate", 320, "")
+	source := flags.String("source", "", "")
+	nochunked := flags.Bool("nochunked", false, "")
+	formats := flags.String("formats", "", "")
+	flags.Parse([]string{"-source", "alsa.monitor"})
+	given := map[string]bool{"source": true}
+
+	if err := cfg.applyDefaults(flags, given); err != nil {
+		t.Fatal(err)
+	}
+	if *bitrate != 256 || *source != "alsa.monitor" {
+		t.Fatalf("got bitrate %d source %s", *bitrate, *source)
+	}
+
+	tv := &goupnp.MaybeRootDevice{Root: &goupnp.RootDevice{}}
+	tv.Root.Device.FriendlyName = "Samsung TV"
+	name, profile, ok := cfg.profile(tv)
+	if !ok || name != "Samsung TV" {
+		t.Fatal("no profile for Samsung TV")
+	}
+	if err := setFlags(flags, given, profile, name); err != nil {
+		t.Fatal(err)
+	}
+	if !*nochunked || *formats != "wav" {
+		t.Fatalf("got nochunked %v formats %s", *nochunked, *formats)
+	}
+
+	sonos := &goupnp.MaybeRootDevice{Root: &goupnp.RootDevice{}}
+	sonos.Root.Device.UDN = "uuid:rincon_1400"
+	sess := &session{volume: -1, cfg: cfg, given: map[string]bool{}}
+	volume, protocol := sess.deviceOptions(sonos)
+	if volume != 20 || protocol != "x-rincon-mp3radio" {
+		t.Fatalf("got volume %d protocol %s", volume, protocol)
+	}
+	sess.given["volume"] = true
+	if volume, _ := sess.deviceOptions(sonos); volume != -1 {
+		t.Fatalf("volume flag should win, got %d", volume)
+	}
+}
+
+func TestSetPresets(t *testing.T) {
+	flags := flag.NewFlagSet("blast", flag.ContinueOnError)
+	useflac := flags.Bool("useflac", false, "")
+	usewav := flags.Bool("usewav", false, "")
+	flags.Parse(nil)
+	given := map[string]bool{}
+
+	// the profile's preset replaces the config default
+	setFlags(flags, given, map[string]any{"useflac": true}, "config")
+	setFlags(flags, given, map[string]any{"usewav": true}, "profile")
+	if *useflac || !*usewav {
+		t.Fatalf("got useflac %v usewav %v", *useflac, *usewav)
+	}
+
+	// the command line's preset wins
+	flags.Parse([]string{"-useflac"})
+	given["useflac"] = true
+	*usewav = false
+	setFlags(flags, given, map[string]any{"usewav": true}, "profile")
+	if !*useflac || *usewav {
+		t.Fatalf("got useflac %v usewav %v", *useflac, *usewav)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := loadConfig(filepath.Join(dir, "missing.toml"), false); err != nil {
+		t.Fatalf("missing default config: %v", err)
+	}
+	if _, err := loadConfig(filepath.Join(dir, "missing.toml"), true); err == nil {
+		t.Fatal("expected an error for a missing explicit config")
+	}
+	path := filepath.Join(dir, "config.toml")
+	os.WriteFile(path, []byte("[profiles.TV]\ndevice = \"Kitchen\"\n"), 0644)
+	if _, err := loadConfig(path, true); err == nil {
+		t.Fatal("expected an error for a device option in a profile")
+	}
+}
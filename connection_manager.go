@@ -0,0 +1,89 @@
+// MIT+NoAI License
+//
+// Copyright (c) 2023 ugjka <ugjka@proton.me>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights///
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// This code may not be used to train artificial intelligence computer models
+// or retrieved by artificial intelligence software or hardware.
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/huin/goupnp"
+	"github.com/huin/goupnp/dcps/av1"
+)
+
+type connectionmanager interface {
+	GetProtocolInfo() (Source string, Sink string, err error)
+}
+
+func detectConnectionManager(dev *goupnp.MaybeRootDevice) string {
+	manager := dev.Root.Device.FindService(av1.URN_ConnectionManager_1)
+	if len(manager) > 0 {
+		return av1.URN_ConnectionManager_1
+	}
+	manager = dev.Root.Device.FindService(av1.URN_ConnectionManager_2)
+	if len(manager) > 0 {
+		return av1.URN_ConnectionManager_2
+	}
+	return ""
+}
+
+func newConnectionManagerClient(dev *goupnp.MaybeRootDevice) (connectionmanager, error) {
+	urn := detectConnectionManager(dev)
+
+	switch {
+	case urn == av1.URN_ConnectionManager_1:
+		clients, err := av1.NewConnectionManager1ClientsByURL(dev.Location)
+		if err != nil {
+			return nil, err
+		}
+		return connectionmanager(clients[0]), nil
+	case urn == av1.URN_ConnectionManager_2:
+		clients, err := av1.NewConnectionManager2ClientsByURL(dev.Location)
+		if err != nil {
+			return nil, err
+		}
+		return connectionmanager(clients[0]), nil
+	}
+	return nil, fmt.Errorf("no connectionmanager found")
+}
+
+// CMSinkProtocols returns the protocolInfo entries the renderer can play
+func CMSinkProtocols(dev *goupnp.MaybeRootDevice) ([]string, error) {
+	client, err := newConnectionManagerClient(dev)
+	if err != nil {
+		return nil, err
+	}
+	_, sink, err := client.GetProtocolInfo()
+	if err != nil {
+		return nil, fmt.Errorf("protocol info: %v", err)
+	}
+	var protocols []string
+	for _, protocol := range strings.Split(sink, ",") {
+		protocol = strings.TrimSpace(protocol)
+		if protocol != "" {
+			protocols = append(protocols, protocol)
+		}
+	}
+	return protocols, nil
+}
@@ -0,0 +1,145 @@
+// MIT+NoAI License
+//
+// Copyright (c) 2023 ugjka <ugjka@proton.me>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights///
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// This code may not be used to train artificial intelligence computer models
+// or retrieved by artificial intelligence software or hardware.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// serveControl serves the control api on a unix socket, e.g.
+//
+//	curl --unix-socket blast.sock http://blast/status
+//	curl --unix-socket blast.sock -d volume=30 http://blast/volume
+func serveControl(path string, sess *session, shutdown func()) error {
+	// remove a stale socket from a previous run
+	if _, err := os.Stat(path); err == nil {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			conn.Close()
+			return fmt.Errorf("%s: already in use", path)
+		}
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sess.status())
+	})
+	mux.HandleFunc("/stop", post(func(r *http.Request) error {
+		// reply before exiting
+		go shutdown()
+		return nil
+	}))
+	mux.HandleFunc("/restart", post(func(r *http.Request) error {
+		return sess.restart()
+	}))
+	mux.HandleFunc("/device", post(func(r *http.Request) error {
+		lookup := r.FormValue("device")
+		if lookup == "" {
+			return fmt.Errorf("no device given")
+		}
+		devices, err := chooseUPNPDevices(lookup)
+		if err != nil {
+			return err
+		}
+		return sess.switchDevices(devices)
+	}))
+	mux.HandleFunc("/source", post(func(r *http.Request) error {
+		lookup := r.FormValue("source")
+		if lookup == "" {
+			return fmt.Errorf("no source given")
+		}
+		source, err := chooseAudioSource(lookup)
+		if err != nil {
+			return err
+		}
+		return sess.switchSource(source)
+	}))
+	mux.HandleFunc("/volume", post(func(r *http.Request) error {
+		// volume sets the volume, change is relative to the current one
+		if volume := r.FormValue("volume"); volume != "" {
+			v, err := strconv.Atoi(volume)
+			if err != nil {
+				return fmt.Errorf("bad volume: %s", volume)
+			}
+			sess.setVolume(v)
+			return nil
+		}
+		if change := r.FormValue("change"); change != "" {
+			delta, err := strconv.Atoi(change)
+			if err != nil {
+				return fmt.Errorf("bad volume change: %s", change)
+			}
+			sess.changeVolume(delta)
+			return nil
+		}
+		return fmt.Errorf("no volume given")
+	}))
+	mux.HandleFunc("/mute", post(func(r *http.Request) error {
+		sess.toggleMute()
+		return nil
+	}))
+	mux.HandleFunc("/title", post(func(r *http.Request) error {
+		// an empty title goes back to the source's now playing info
+		sess.setTitle(nowPlaying{
+			Title:  r.FormValue("title"),
+			Artist: r.FormValue("artist"),
+		})
+		return nil
+	}))
+	log.Printf("control api on %s", path)
+	go func() {
+		err := http.Serve(listener, mux)
+		if err != nil {
+			log.Println("control:", err)
+		}
+	}()
+	return nil
+}
+
+func post(action func(r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		err := action(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	}
+}
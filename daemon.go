@@ -0,0 +1,337 @@
+// MIT+NoAI License
+//
+// Copyright (c) 2023 ugjka <ugjka@proton.me>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights///
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// This code may not be used to train artificial intelligence computer models
+// or retrieved by artificial intelligence software or hardware.
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/huin/goupnp"
+	"github.com/huin/goupnp/httpu"
+	"github.com/huin/goupnp/ssdp"
+)
+
+const (
+	SSDP_ADDR = "239.255.255.250:1900"
+	// how often the renderers that went silent are looked for
+	DAEMON_SWEEP = time.Minute
+	// how long a renderer found by searching is trusted
+	// without hearing from it, the usual max-age
+	DAEMON_MAX_AGE = 30 * time.Minute
+)
+
+// daemon keeps a live registry of the renderers on the network from
+// their ssdp notifications, it casts to the wanted ones when they show
+// up and forgets them when they leave
+type daemon struct {
+	sess    *session
+	lookups []string
+	mu      sync.Mutex
+	// by the UDN of their USN
+	renderers map[string]*networkRenderer
+	// the descriptions being fetched
+	loading map[string]bool
+}
+
+type networkRenderer struct {
+	dev    *goupnp.MaybeRootDevice
+	bootID int32
+	// found by searching, the boot id isn't known yet
+	searched bool
+	expires  time.Time
+	wanted   bool
+	// stopped on the renderer or moved to another source, it isn't
+	// cast to again until it leaves the network or restarts
+	stopped bool
+}
+
+// newDaemon finds the renderers that are already on the network, the
+// wanted ones are returned by devices. lookup is the same as for
+// chooseUPNPDevices but none of the renderers has to be there yet.
+func newDaemon(lookup string) (*daemon, error) {
+	d := &daemon{
+		renderers: make(map[string]*networkRenderer),
+		loading:   make(map[string]bool),
+	}
+	for _, name := range strings.Split(lookup, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if strings.HasPrefix(name, "re:") {
+			_, err := regexp.Compile(strings.TrimPrefix(name, "re:"))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+		}
+		d.lookups = append(d.lookups, name)
+	}
+	if len(d.lookups) == 0 {
+		return nil, fmt.Errorf("no device given")
+	}
+	roots, err := discoverDevices()
+	if err != nil {
+		return nil, err
+	}
+	for i := range roots {
+		dev := &roots[i]
+		if dev.Root == nil {
+			continue
+		}
+		d.renderers[usnUDN(dev.USN)] = &networkRenderer{
+			dev:      dev,
+			searched: true,
+			expires:  time.Now().Add(DAEMON_MAX_AGE),
+			wanted:   d.wanted(dev),
+		}
+	}
+	return d, nil
+}
+
+// devices returns the wanted renderers
+func (d *daemon) devices() []*goupnp.MaybeRootDevice {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var devices []*goupnp.MaybeRootDevice
+	for _, r := range d.renderers {
+		if r.wanted {
+			devices = append(devices, r.dev)
+		}
+	}
+	return devices
+}
+
+// wanted reports whether any of the lookups matches the device
+func (d *daemon) wanted(dev *goupnp.MaybeRootDevice) bool {
+	for _, lookup := range d.lookups {
+		_, err := findUPNPDevice([]goupnp.MaybeRootDevice{*dev}, lookup)
+		if err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// listen starts listening to the ssdp notifications
+func (d *daemon) listen(sess *session) error {
+	addr, err := net.ResolveUDPAddr("udp4", SSDP_ADDR)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenMulticastUDP("udp4", discover.iface, addr)
+	if err != nil {
+		return err
+	}
+	d.sess = sess
+	reg := ssdp.NewRegistry()
+	// the registry blocks until the update is taken
+	updates := make(chan ssdp.Update, 64)
+	reg.AddListener(updates)
+	go func() {
+		for u := range updates {
+			d.handle(u)
+		}
+	}()
+	go func() {
+		for range time.Tick(DAEMON_SWEEP) {
+			d.sweep()
+		}
+	}()
+	go func() {
+		err := (&httpu.Server{Handler: reg}).Serve(conn)
+		log.Printf("daemon: %v", err)
+	}()
+	log.Printf("daemon: watching the network for %s", strings.Join(d.lookups, ", "))
+	return nil
+}
+
+func (d *daemon) handle(u ssdp.Update) {
+	udn := usnUDN(u.USN)
+	if u.EventType == ssdp.EventByeBye {
+		d.gone(udn, "said byebye")
+		return
+	}
+	entry := u.Entry
+	if !isRendererNT(entry.NT) {
+		return
+	}
+	d.mu.Lock()
+	if d.loading[udn] {
+		d.mu.Unlock()
+		return
+	}
+	r, ok := d.renderers[udn]
+	known := ok && r.dev.Location.String() == entry.Location.String()
+	if known {
+		r.expires = entry.CacheExpiry
+		if r.searched {
+			r.searched = false
+			r.bootID = entry.BootID
+		}
+	}
+	// a new boot id means the renderer restarted
+	restarted := known && r.bootID != entry.BootID
+	if known && !restarted && !r.wanted {
+		d.mu.Unlock()
+		return
+	}
+	d.loading[udn] = true
+	d.mu.Unlock()
+
+	// most renderers don't send a boot id, one that lost its power
+	// comes back with the same one, so cast again unless it plays
+	// or the user stopped it
+	if known && !restarted {
+		casting := d.sess.casting(r.dev)
+		d.mu.Lock()
+		if casting {
+			// played again, e.g. from the control api
+			r.stopped = false
+		}
+		skip := casting || r.stopped
+		if skip {
+			delete(d.loading, udn)
+		}
+		d.mu.Unlock()
+		if skip {
+			return
+		}
+	}
+	go d.load(udn, *entry)
+}
+
+// stoppedOn keeps the renderer from being cast to again after
+// the user stopped it or moved it to another source
+func (d *daemon) stoppedOn(dev *goupnp.MaybeRootDevice) {
+	if dev.Root == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for udn, r := range d.renderers {
+		if strings.EqualFold(udn, dev.Root.Device.UDN) {
+			r.stopped = true
+		}
+	}
+}
+
+// load fetches the description of a renderer that appeared
+// and casts to it if it's wanted
+func (d *daemon) load(udn string, entry ssdp.Entry) {
+	defer func() {
+		d.mu.Lock()
+		delete(d.loading, udn)
+		d.mu.Unlock()
+	}()
+	root, err := goupnp.DeviceByURL(&entry.Location)
+	if err != nil {
+		log.Printf("daemon: %s: %v", entry.Location.String(), err)
+		return
+	}
+	dev := &goupnp.MaybeRootDevice{
+		USN:      entry.USN,
+		Root:     root,
+		Location: &entry.Location,
+	}
+	r := &networkRenderer{
+		dev:     dev,
+		bootID:  entry.BootID,
+		expires: entry.CacheExpiry,
+		wanted:  d.wanted(dev),
+	}
+	d.mu.Lock()
+	d.renderers[udn] = r
+	d.mu.Unlock()
+	log.Printf("daemon: %s appeared at %s", deviceName(dev), dev.Location.Host)
+	if !r.wanted {
+		return
+	}
+	err = d.sess.addDevice(dev)
+	if err != nil {
+		log.Printf("transport: %s: %v", deviceName(dev), err)
+	}
+}
+
+// gone forgets a renderer that left the network
+func (d *daemon) gone(udn, reason string) {
+	d.mu.Lock()
+	r, ok := d.renderers[udn]
+	delete(d.renderers, udn)
+	d.mu.Unlock()
+	if !ok {
+		return
+	}
+	log.Printf("daemon: %s %s", deviceName(r.dev), reason)
+	if r.wanted {
+		d.sess.removeDevice(r.dev.Root.Device.UDN)
+	}
+}
+
+// sweep forgets the renderers that didn't renew their notifications,
+// e.g. when their power was cut. The ones still pulling the stream stay.
+func (d *daemon) sweep() {
+	d.mu.Lock()
+	expired := make(map[string]*goupnp.MaybeRootDevice)
+	for udn, r := range d.renderers {
+		if time.Now().After(r.expires) {
+			expired[udn] = r.dev
+		}
+	}
+	d.mu.Unlock()
+	for udn, dev := range expired {
+		if !d.sess.streaming(dev) {
+			d.gone(udn, "went silent")
+		}
+	}
+}
+
+// list returns the renderers on the network for the status
+func (d *daemon) list() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var names []string
+	for _, r := range d.renderers {
+		names = append(names, deviceName(r.dev))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// usnUDN returns the device part of a USN, e.g. uuid:...
+func usnUDN(usn string) string {
+	udn, _, _ := strings.Cut(usn, "::")
+	return udn
+}
+
+func isRendererNT(nt string) bool {
+	return strings.Contains(nt, ":service:AVTransport:") ||
+		strings.Contains(nt, ":device:MediaRenderer:")
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/huin/goupnp"
+	"github.com/huin/goupnp/dcps/av1"
+	"github.com/huin/goupnp/ssdp"
+)
+
+func TestDaemonUpdates(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, testDescription, "TV", "uuid:tv", av1.URN_AVTransport_1)
+	}))
+	defer srv.Close()
+	loc, _ := url.Parse(srv.URL + "/desc.xml")
+	dev := &goupnp.MaybeRootDevice{
+		USN:      "uuid:tv::urn:schemas-upnp-org:service:AVTransport:1",
+		Root:     &goupnp.RootDevice{},
+		Location: loc,
+	}
+	dev.Root.Device.FriendlyName = "TV"
+	dev.Root.Device.UDN = "uuid:tv"
+	sess := &session{
+		devices: []*goupnp.MaybeRootDevice{dev},
+		volume:  -1,
+		streams: []stream{{pipe: &pipeline{}}},
+		meta:    &metadata{},
+		subs:    make(map[string]*subscription),
+		states:  make(map[*goupnp.MaybeRootDevice]*rendererState),
+	}
+	d := &daemon{
+		sess:    sess,
+		lookups: []string{"TV"},
+		renderers: map[string]*networkRenderer{
+			"uuid:tv": {dev: dev, searched: true},
+		},
+		loading: make(map[string]bool),
+	}
+	alive := ssdp.Update{
+		USN:       dev.USN,
+		EventType: ssdp.EventAlive,
+		Entry: &ssdp.Entry{
+			USN:         dev.USN,
+			NT:          "urn:schemas-upnp-org:service:AVTransport:1",
+			Location:    *loc,
+			BootID:      5,
+			CacheExpiry: time.Now().Add(time.Hour),
+		},
+	}
+	loaded := func() bool {
+		for i := 0; i < 100; i++ {
+			d.mu.Lock()
+			loading := len(d.loading)
+			d.mu.Unlock()
+			if loading == 0 {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return d.renderers["uuid:tv"].dev != dev
+	}
+
+	// the first notification of a searched renderer isn't a restart
+	d.handle(alive)
+	r := d.renderers["uuid:tv"]
+	if r.searched || r.bootID != 5 || loaded() {
+		t.Fatalf("searched renderer was reloaded: %+v", r)
+	}
+
+	// other devices are ignored
+	other := alive
+	other.USN = "uuid:router::upnp:rootdevice"
+	other.Entry = &ssdp.Entry{USN: other.USN, NT: "upnp:rootdevice", Location: *loc}
+	d.handle(other)
+	if len(d.renderers) != 1 || len(d.loading) != 0 {
+		t.Fatal("a non-renderer was added")
+	}
+
+	// a wanted renderer that isn't playing came back, e.g. from a
+	// power cut, with the same boot id
+	r.wanted = true
+	d.handle(alive)
+	if !loaded() {
+		t.Fatal("the renderer wasn't cast to again")
+	}
+	if len(sess.devices) != 1 || sess.devices[0] == dev {
+		t.Fatalf("the session has %d devices", len(sess.devices))
+	}
+
+	// stopped on the renderer, its next notifications don't cast again
+	cast := d.renderers["uuid:tv"].dev
+	sess.daemon = d
+	sess.playing = true
+	sess.active = map[*goupnp.MaybeRootDevice]avsetup{cast: {device: cast}}
+	sess.quit = map[*goupnp.MaybeRootDevice]chan struct{}{cast: make(chan struct{})}
+	sess.rendererGone(cast, "stopped on the renderer")
+	dev = cast
+	d.handle(alive)
+	if loaded() {
+		t.Fatal("the stopped renderer was cast to again")
+	}
+
+	// until it restarts
+	restarted := alive
+	restarted.Entry = &ssdp.Entry{}
+	*restarted.Entry = *alive.Entry
+	restarted.Entry.BootID = 6
+	d.handle(restarted)
+	if !loaded() {
+		t.Fatal("the restarted renderer wasn't cast to again")
+	}
+
+	d.handle(ssdp.Update{USN: dev.USN, EventType: ssdp.EventByeBye})
+	if len(d.renderers) != 0 || len(sess.devices) != 0 {
+		t.Fatal("byebye didn't remove the renderer")
+	}
+}
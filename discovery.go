@@ -0,0 +1,332 @@
+// MIT+NoAI License
+//
+// Copyright (c) 2023 ugjka <ugjka@proton.me>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights///
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// This code may not be used to train artificial intelligence computer models
+// or retrieved by artificial intelligence software or hardware.
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/huin/goupnp"
+	"github.com/huin/goupnp/dcps/av1"
+	"github.com/huin/goupnp/httpu"
+)
+
+const (
+	SSDP_PORT = "1900"
+	// mx can't be more than 5 seconds
+	SSDP_MAX_MX = 5
+	// every search is sent this many times, udp gets lost
+	SSDP_SENDS = 3
+)
+
+// the search targets that have a short name
+var searchTargets = map[string]string{
+	"avtransport:1":   av1.URN_AVTransport_1,
+	"avtransport:2":   av1.URN_AVTransport_2,
+	"mediarenderer:1": "urn:schemas-upnp-org:device:MediaRenderer:1",
+	"mediarenderer:2": "urn:schemas-upnp-org:device:MediaRenderer:2",
+	"ssdp:all":        "ssdp:all",
+}
+
+// discovery says how the renderers are searched for
+type discovery struct {
+	timeout time.Duration
+	targets []string
+	// the interface the searches are sent from, all of them when nil
+	iface  *net.Interface
+	passes int
+	// hosts that are also searched over unicast,
+	// for networks where multicast doesn't reach
+	probes []string
+}
+
+var discover = discovery{
+	timeout: 2 * time.Second,
+	targets: []string{av1.URN_AVTransport_1, av1.URN_AVTransport_2},
+	passes:  1,
+}
+
+// newDiscovery checks the discovery flags, targets and
+// probes are comma separated
+func newDiscovery(timeout time.Duration, targets, iface string, passes int, probes string) (discovery, error) {
+	d := discovery{timeout: timeout, passes: passes}
+	if timeout < time.Second {
+		return d, fmt.Errorf("the timeout must be at least 1s")
+	}
+	if passes < 1 {
+		return d, fmt.Errorf("there must be at least one pass")
+	}
+	for _, target := range strings.Split(targets, ",") {
+		target = strings.TrimSpace(target)
+		if target == "" {
+			continue
+		}
+		if urn, ok := searchTargets[strings.ToLower(target)]; ok {
+			target = urn
+		} else if !strings.HasPrefix(target, "urn:") && !strings.HasPrefix(target, "uuid:") {
+			return d, fmt.Errorf("%s: unknown search target", target)
+		}
+		d.targets = append(d.targets, target)
+	}
+	if len(d.targets) == 0 {
+		return d, fmt.Errorf("no search target given")
+	}
+	if iface != "" {
+		i, err := net.InterfaceByName(iface)
+		if err != nil {
+			return d, fmt.Errorf("%s: %v", iface, err)
+		}
+		d.iface = i
+	}
+	for _, host := range strings.Split(probes, ",") {
+		host = strings.TrimSpace(host)
+		if host == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(strings.Trim(host, "[]"), SSDP_PORT)
+		}
+		d.probes = append(d.probes, host)
+	}
+	return d, nil
+}
+
+func discoverDevices() ([]goupnp.MaybeRootDevice, error) {
+	roots, err := discover.devices()
+	if err != nil {
+		return nil, fmt.Errorf("discover: %v", err)
+	}
+	return roots, nil
+}
+
+// searchResult is a device that answered a search
+type searchResult struct {
+	usn       string
+	location  *url.URL
+	localAddr net.IP
+}
+
+// devices runs the searches of every pass at once, the devices
+// that answered are merged by their UDN
+func (d discovery) devices() ([]goupnp.MaybeRootDevice, error) {
+	var results []searchResult
+	for pass := 0; pass < d.passes; pass++ {
+		var (
+			wg      sync.WaitGroup
+			mu      sync.Mutex
+			lastErr error
+		)
+		search := func(host, target string) {
+			defer wg.Done()
+			found, err := d.search(host, target)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				lastErr = err
+				return
+			}
+			results = append(results, found...)
+		}
+		for _, target := range d.targets {
+			wg.Add(1)
+			go search("", target)
+			for _, host := range d.probes {
+				wg.Add(1)
+				go search(host, target)
+			}
+		}
+		wg.Wait()
+		// a failed probe is fine as long as something was searched
+		if lastErr != nil && len(results) == 0 {
+			return nil, lastErr
+		}
+	}
+	return d.resolve(results), nil
+}
+
+// search sends the search to the multicast group, or to the host
+func (d discovery) search(host, target string) ([]searchResult, error) {
+	multicast := host == ""
+	var client httpu.ClientInterfaceCtx
+	if multicast {
+		host = net.JoinHostPort("239.255.255.250", SSDP_PORT)
+		c, closeClient, err := d.multicastClient()
+		if err != nil {
+			return nil, err
+		}
+		defer closeClient()
+		client = c
+	} else {
+		c, err := httpu.NewHTTPUClient()
+		if err != nil {
+			return nil, err
+		}
+		defer c.Close()
+		client = c
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	req := (&http.Request{
+		Method: "M-SEARCH",
+		Host:   host,
+		URL:    &url.URL{Opaque: "*"},
+		// as is, ssdp headers are case sensitive
+		Header: http.Header{
+			"HOST": {host},
+			"MAN":  {`"ssdp:discover"`},
+			"ST":   {target},
+		},
+	}).WithContext(ctx)
+	// unicast searches are answered right away
+	if multicast {
+		mx := int(d.timeout / time.Second)
+		if mx > SSDP_MAX_MX {
+			mx = SSDP_MAX_MX
+		}
+		req.Header["MX"] = []string{fmt.Sprint(mx)}
+	}
+	responses, err := client.DoWithContext(req, SSDP_SENDS)
+	if err != nil {
+		return nil, err
+	}
+
+	exact := target != "ssdp:all" && target != "upnp:rootdevice"
+	var results []searchResult
+	for _, resp := range responses {
+		if resp.StatusCode != http.StatusOK {
+			continue
+		}
+		if exact && resp.Header.Get("ST") != target {
+			continue
+		}
+		loc, err := resp.Location()
+		if err != nil {
+			continue
+		}
+		results = append(results, searchResult{
+			usn:       resp.Header.Get("USN"),
+			location:  loc,
+			localAddr: net.ParseIP(resp.Header.Get(httpu.LocalAddressHeader)),
+		})
+	}
+	return results, nil
+}
+
+// multicastClient sends from every ipv4 address of the interface,
+// or of every multicast interface
+func (d discovery) multicastClient() (httpu.ClientInterfaceCtx, func(), error) {
+	ifaces := []net.Interface{}
+	if d.iface != nil {
+		ifaces = append(ifaces, *d.iface)
+	} else {
+		all, err := net.Interfaces()
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, iface := range all {
+			if iface.Flags&net.FlagMulticast != 0 &&
+				iface.Flags&net.FlagLoopback == 0 &&
+				iface.Flags&net.FlagUp != 0 {
+				ifaces = append(ifaces, iface)
+			}
+		}
+	}
+	var clients []*httpu.HTTPUClient
+	closeClients := func() {
+		for _, c := range clients {
+			c.Close()
+		}
+	}
+	var delegates []httpu.ClientInterfaceCtx
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			closeClients()
+			return nil, nil, fmt.Errorf("%s: %v", iface.Name, err)
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok || ipnet.IP.To4() == nil {
+				continue
+			}
+			c, err := httpu.NewHTTPUClientAddr(ipnet.IP.String())
+			if err != nil {
+				closeClients()
+				return nil, nil, err
+			}
+			clients = append(clients, c)
+			delegates = append(delegates, c)
+		}
+	}
+	if len(delegates) == 0 {
+		return nil, nil, fmt.Errorf("no ipv4 multicast interface found")
+	}
+	return httpu.NewMultiClientCtx(delegates), closeClients, nil
+}
+
+// resolve fetches the description of every location once and keeps
+// a single entry per device, the ones without an AVTransport are dropped
+func (d discovery) resolve(results []searchResult) []goupnp.MaybeRootDevice {
+	var (
+		roots    []goupnp.MaybeRootDevice
+		seenLocs = make(map[string]bool)
+		seenUDNs = make(map[string]bool)
+	)
+	for _, result := range results {
+		loc := result.location.String()
+		if seenLocs[loc] {
+			continue
+		}
+		seenLocs[loc] = true
+		dev := goupnp.MaybeRootDevice{
+			USN:       result.usn,
+			Location:  result.location,
+			LocalAddr: result.localAddr,
+		}
+		dev.Root, dev.Err = goupnp.DeviceByURL(result.location)
+		if dev.Err != nil {
+			roots = append(roots, dev)
+			continue
+		}
+		if detectAVtransport(&dev) == "" {
+			continue
+		}
+		// the same device at another address, e.g. on two interfaces
+		udn := strings.ToLower(dev.Root.Device.UDN)
+		if seenUDNs[udn] {
+			continue
+		}
+		seenUDNs[udn] = true
+		roots = append(roots, dev)
+	}
+	return roots
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/huin/goupnp/dcps/av1"
+)
+
+func TestNewDiscovery(t *testing.T) {
+	d, err := newDiscovery(3*time.Second, "AVTransport:2, mediarenderer:1,ssdp:all", "", 2, "10.2.0.15,10.2.0.16:1901")
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets := fmt.Sprint(d.targets)
+	want := fmt.Sprint([]string{
+		av1.URN_AVTransport_2,
+		"urn:schemas-upnp-org:device:MediaRenderer:1",
+		"ssdp:all",
+	})
+	if targets != want {
+		t.Errorf("targets: got %s, want %s", targets, want)
+	}
+	if fmt.Sprint(d.probes) != "[10.2.0.15:1900 10.2.0.16:1901]" {
+		t.Errorf("probes: got %v", d.probes)
+	}
+	for _, bad := range []string{"", "AVTransport:3"} {
+		if _, err := newDiscovery(time.Second, bad, "", 1, ""); err == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+	if _, err := newDiscovery(time.Millisecond, "ssdp:all", "", 1, ""); err == nil {
+		t.Error("a timeout under a second was accepted")
+	}
+}
+
+const testDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+<specVersion><major>1</major><minor>0</minor></specVersion>
+<device><friendlyName>%s</friendlyName><UDN>%s</UDN>
+<serviceList><service><serviceType>%s</serviceType></service></serviceList>
+</device></root>`
+
+func TestResolveMergesByUDN(t *testing.T) {
+	mux := http.NewServeMux()
+	describe := func(path, name, udn, service string) {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, testDescription, name, udn, service)
+		})
+	}
+	describe("/tv.xml", "TV", "uuid:tv", av1.URN_AVTransport_2)
+	describe("/tv-wifi.xml", "TV", "uuid:TV", av1.URN_AVTransport_2)
+	describe("/router.xml", "Router", "uuid:router", "urn:schemas-upnp-org:service:WANIPConnection:1")
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	var results []searchResult
+	for _, path := range []string{"/tv.xml", "/tv.xml", "/tv-wifi.xml", "/router.xml"} {
+		loc, _ := url.Parse(srv.URL + path)
+		results = append(results, searchResult{location: loc})
+	}
+	roots := discovery{}.resolve(results)
+	if len(roots) != 1 || roots[0].Root.Device.UDN != "uuid:tv" {
+		t.Fatalf("got %d devices, want only the tv", len(roots))
+	}
+}
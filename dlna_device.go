@@ -26,17 +26,22 @@ package main
 
 import (
 	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
 
 	"github.com/huin/goupnp"
-	"github.com/huin/goupnp/dcps/av1"
 )
 
-func chooseUPNPDevice(lookup string) (*goupnp.MaybeRootDevice, error) {
+// chooseUPNPDevices returns the renderers to cast to. lookup is a comma
+// separated list of devices (see findUPNPDevice), when it is empty the user
+// is asked to pick one or more devices from the discovered ones.
+func chooseUPNPDevices(lookup string) ([]*goupnp.MaybeRootDevice, error) {
 	if lookup == "" {
 		fmt.Println("Loading...")
 	}
 
-	roots, err := goupnp.DiscoverDevices(av1.URN_AVTransport_1)
+	roots, err := discoverDevices()
 
 	if lookup == "" {
 		fmt.Print("\033[1A\033[K")
@@ -44,17 +49,25 @@ func chooseUPNPDevice(lookup string) (*goupnp.MaybeRootDevice, error) {
 	}
 
 	if err != nil {
-		return nil, fmt.Errorf("discover: %v", err)
+		return nil, err
 	}
 	if lookup != "" {
-		for _, v := range roots {
-			if v.Root != nil {
-				if v.Root.Device.FriendlyName == lookup {
-					return &v, nil
-				}
+		var devices []*goupnp.MaybeRootDevice
+		for _, name := range strings.Split(lookup, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
 			}
+			dev, err := findUPNPDevice(roots, name)
+			if err != nil {
+				return nil, err
+			}
+			devices = append(devices, dev)
 		}
-		return nil, fmt.Errorf("%s: not found", lookup)
+		if len(devices) == 0 {
+			return nil, fmt.Errorf("%s: not found", lookup)
+		}
+		return devices, nil
 	}
 
 	if len(roots) == 0 {
@@ -69,8 +82,129 @@ func chooseUPNPDevice(lookup string) (*goupnp.MaybeRootDevice, error) {
 	}
 
 	fmt.Println("----------")
-	fmt.Println("Select the DLNA device:")
+	fmt.Println("Select the DLNA devices (e.g. 0 or 0,2):")
+
+	var devices []*goupnp.MaybeRootDevice
+	for _, selected := range multiSelector(roots) {
+		devices = append(devices, &roots[selected])
+	}
+	return devices, nil
+}
+
+// loadUPNPDevices loads the devices from their description urls,
+// comma separated, without searching for them
+func loadUPNPDevices(urls string) ([]*goupnp.MaybeRootDevice, error) {
+	var devices []*goupnp.MaybeRootDevice
+	for _, raw := range strings.Split(urls, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		dev, err := loadUPNPDevice(raw)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, dev)
+	}
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("no device url given")
+	}
+	return devices, nil
+}
+
+func loadUPNPDevice(raw string) (*goupnp.MaybeRootDevice, error) {
+	loc, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if loc.Scheme != "http" && loc.Scheme != "https" || loc.Host == "" {
+		return nil, fmt.Errorf("%s: not an http url", raw)
+	}
+	root, err := goupnp.DeviceByURL(loc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", raw, err)
+	}
+	dev := &goupnp.MaybeRootDevice{
+		Root:     root,
+		Location: loc,
+	}
+	urn := detectAVtransport(dev)
+	if urn == "" {
+		return nil, fmt.Errorf("%s: %s has no AVTransport service",
+			raw, root.Device.FriendlyName)
+	}
+	// what a search for the transport would have answered
+	dev.USN = root.Device.UDN + "::" + urn
+	return dev, nil
+}
+
+// findUPNPDevice looks up a device by its friendly name, UDN (uuid:...),
+// the host or ip address of its location or a regular expression
+// matching the friendly name (re:...)
+func findUPNPDevice(roots []goupnp.MaybeRootDevice, lookup string) (*goupnp.MaybeRootDevice, error) {
+	var match func(dev *goupnp.MaybeRootDevice) bool
+
+	switch {
+	case strings.HasPrefix(lookup, "re:"):
+		re, err := regexp.Compile(strings.TrimPrefix(lookup, "re:"))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", lookup, err)
+		}
+		match = func(dev *goupnp.MaybeRootDevice) bool {
+			return re.MatchString(dev.Root.Device.FriendlyName)
+		}
+	case strings.HasPrefix(strings.ToLower(lookup), "uuid:"):
+		match = func(dev *goupnp.MaybeRootDevice) bool {
+			return strings.EqualFold(dev.Root.Device.UDN, lookup)
+		}
+	default:
+		match = func(dev *goupnp.MaybeRootDevice) bool {
+			return dev.Root.Device.FriendlyName == lookup
+		}
+		if matchDevices(roots, match) == nil {
+			host := lookupHost(lookup)
+			match = func(dev *goupnp.MaybeRootDevice) bool {
+				return strings.EqualFold(dev.Location.Hostname(), lookup) ||
+					lookupHost(dev.Location.Hostname()) == host
+			}
+		}
+	}
 
-	selected := selector(roots)
-	return &roots[selected], nil
+	matches := matchDevices(roots, match)
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("%s: not found", lookup)
+	case 1:
+		return matches[0], nil
+	}
+	var candidates []string
+	for _, dev := range matches {
+		candidates = append(candidates, fmt.Sprintf(
+			"%s (%s, %s)",
+			dev.Root.Device.FriendlyName,
+			dev.Root.Device.UDN,
+			dev.Location.Host,
+		))
+	}
+	return nil, fmt.Errorf(
+		"%s: ambiguous, matches %s",
+		lookup, strings.Join(candidates, "; "),
+	)
+}
+
+func matchDevices(roots []goupnp.MaybeRootDevice, match func(*goupnp.MaybeRootDevice) bool) []*goupnp.MaybeRootDevice {
+	var matches []*goupnp.MaybeRootDevice
+	for i := range roots {
+		if roots[i].Root != nil && match(&roots[i]) {
+			matches = append(matches, &roots[i])
+		}
+	}
+	return matches
+}
+
+func deviceName(dev *goupnp.MaybeRootDevice) string {
+	if dev.Root != nil {
+		return dev.Root.Device.FriendlyName
+	}
+	return dev.Location.String()
 }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/huin/goupnp"
+	"github.com/huin/goupnp/dcps/av1"
+)
+
+func TestFindUPNPDevice(t *testing.T) {
+	device := func(name, udn, location string) goupnp.MaybeRootDevice {
+		loc, _ := url.Parse(location)
+		root := &goupnp.RootDevice{}
+		root.Device.FriendlyName = name
+		root.Device.UDN = udn
+		return goupnp.MaybeRootDevice{Root: root, Location: loc}
+	}
+	roots := []goupnp.MaybeRootDevice{
+		device("Kitchen", "uuid:1111", "http://192.168.1.10:49152/desc.xml"),
+		device("Living Room", "uuid:2222", "http://192.168.1.11:1400/desc.xml"),
+		device("Living Room", "uuid:3333", "http://192.168.1.12:8080/desc.xml"),
+	}
+
+	tests := []struct {
+		lookup string
+		udn    string
+		err    string
+	}{
+		{"Kitchen", "uuid:1111", ""},
+		{"uuid:2222", "uuid:2222", ""},
+		{"UUID:3333", "uuid:3333", ""},
+		{"192.168.1.12", "uuid:3333", ""},
+		{"re:^Kit", "uuid:1111", ""},
+		{"Living Room", "", "ambiguous"},
+		{"re:o", "", "ambiguous"},
+		{"Bedroom", "", "not found"},
+		{"re:(", "", "re:("},
+	}
+	for _, test := range tests {
+		dev, err := findUPNPDevice(roots, test.lookup)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: got %v, wanted %s error", test.lookup, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.lookup, err)
+			continue
+		}
+		if dev.Root.Device.UDN != test.udn {
+			t.Errorf("%s: got %s, wanted %s",
+				test.lookup, dev.Root.Device.UDN, test.udn)
+		}
+	}
+}
+
+func TestLoadUPNPDevices(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tv.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, testDescription, "TV", "uuid:tv", av1.URN_AVTransport_2)
+	})
+	mux.HandleFunc("/router.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, testDescription, "Router", "uuid:router", "urn:schemas-upnp-org:service:WANIPConnection:1")
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	devices, err := loadUPNPDevices(srv.URL + "/tv.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev := devices[0]
+	if deviceName(dev) != "TV" || dev.USN != "uuid:tv::"+av1.URN_AVTransport_2 ||
+		dev.Location.String() != srv.URL+"/tv.xml" {
+		t.Errorf("got %s %s %s", deviceName(dev), dev.USN, dev.Location)
+	}
+	for _, bad := range []string{srv.URL + "/router.xml", srv.URL + "/missing.xml", "10.2.0.15/tv.xml", ""} {
+		if _, err := loadUPNPDevices(bad); err == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+}
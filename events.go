@@ -0,0 +1,291 @@
+// MIT+NoAI License
+//
+// Copyright (c) 2023 ugjka <ugjka@proton.me>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights///
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// This code may not be used to train artificial intelligence computer models
+// or retrieved by artificial intelligence software or hardware.
+package main
+
+import (
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/huin/goupnp"
+)
+
+// rendererState is what the renderer reported in its events
+type rendererState struct {
+	TransportState  string `json:"state,omitempty"`
+	TransportStatus string `json:"status,omitempty"`
+	Volume          string `json:"volume,omitempty"`
+	Mute            string `json:"mute,omitempty"`
+	URI             string `json:"uri,omitempty"`
+	// the uri blast set, another one means the renderer moved on
+	ours      string
+	stoppedAt time.Time
+}
+
+// subscribeEvents subscribes to the device's transport and volume
+// events, the transport state is polled if it can't send them
+func (s *session) subscribeEvents(av avsetup, quit <-chan struct{}) {
+	dev := av.device
+	s.evmu.Lock()
+	s.states[dev] = &rendererState{ours: av.streamURI}
+	s.evmu.Unlock()
+	transport := false
+	for _, urn := range []string{detectAVtransport(dev), detectRenderingControl(dev)} {
+		if urn == "" || s.eventsURI == "" {
+			continue
+		}
+		s.evmu.Lock()
+		s.nextSub++
+		id := strconv.Itoa(s.nextSub)
+		s.evmu.Unlock()
+		sub, err := newSubscription(dev, urn, s.eventsURI+id)
+		if err != nil {
+			log.Printf("events: %s: %v", deviceName(dev), err)
+			continue
+		}
+		// the first event may arrive before the reply
+		s.evmu.Lock()
+		s.subs[id] = sub
+		s.evmu.Unlock()
+		err = sub.start()
+		if err != nil {
+			log.Printf("events: %s: %s: %v", deviceName(dev), sub.service, err)
+			s.evmu.Lock()
+			delete(s.subs, id)
+			s.evmu.Unlock()
+			continue
+		}
+		if urn == detectAVtransport(dev) {
+			transport = true
+		}
+	}
+	if !transport {
+		go s.pollRenderer(dev, quit)
+	}
+}
+
+// pollRenderer stands in for the events of the renderers that don't send them
+func (s *session) pollRenderer(dev *goupnp.MaybeRootDevice, quit <-chan struct{}) {
+	client, err := newAVTransportClient(dev)
+	if err != nil {
+		return
+	}
+	for {
+		select {
+		case <-quit:
+			return
+		case <-time.After(WATCHDOG_INTERVAL):
+		}
+		if _, ok := s.rendererState(dev); !ok {
+			return
+		}
+		state, status, _, err := client.GetTransportInfo(0)
+		if err != nil {
+			continue
+		}
+		changes := map[string]string{
+			"TransportState":  state,
+			"TransportStatus": status,
+		}
+		_, _, uri, _, _, _, _, _, _, err := client.GetMediaInfo(0)
+		if err == nil {
+			changes["AVTransportURI"] = uri
+		}
+		s.applyEvent(dev, changes)
+	}
+}
+
+// unsubscribeEvents cancels the subscriptions of the device, or all with nil
+func (s *session) unsubscribeEvents(dev *goupnp.MaybeRootDevice) {
+	cancelSubscriptions(s.takeSubscriptions(dev))
+}
+
+// takeSubscriptions forgets the subscriptions of the device, or all with
+// nil. They're cancelled outside s.mu, unsubscribing from a renderer
+// that left takes until the timeout.
+func (s *session) takeSubscriptions(dev *goupnp.MaybeRootDevice) []*subscription {
+	s.evmu.Lock()
+	defer s.evmu.Unlock()
+	var subs []*subscription
+	for id, sub := range s.subs {
+		if dev == nil || sub.dev == dev {
+			subs = append(subs, sub)
+			delete(s.subs, id)
+		}
+	}
+	for d := range s.states {
+		if dev == nil || d == dev {
+			delete(s.states, d)
+		}
+	}
+	return subs
+}
+
+func cancelSubscriptions(subs []*subscription) {
+	for _, sub := range subs {
+		sub.cancel()
+	}
+}
+
+// handleEvent takes the renderers' NOTIFY requests
+func (s *session) handleEvent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "NOTIFY" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	s.evmu.Lock()
+	sub := s.subs[path.Base(r.URL.Path)]
+	s.evmu.Unlock()
+	if sub == nil {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	if sid := sub.currentSID(); sid != "" && sid != r.Header.Get("SID") {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	changes, err := parseEvent(r.Body)
+	if err != nil {
+		log.Printf("events: %s: %v", deviceName(sub.dev), err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	s.applyEvent(sub.dev, changes)
+}
+
+func (s *session) applyEvent(dev *goupnp.MaybeRootDevice, changes map[string]string) {
+	s.evmu.Lock()
+	st := s.states[dev]
+	if st == nil {
+		s.evmu.Unlock()
+		return
+	}
+	name := deviceName(dev)
+	var stopped, switched bool
+	for variable, value := range changes {
+		switch variable {
+		case "TransportState":
+			if value == st.TransportState {
+				continue
+			}
+			log.Printf("%s: %s", name, value)
+			st.TransportState = value
+			// a passing stop, e.g. when setting the uri
+			// again, is sorted out by waiting a while
+			if value == "STOPPED" || value == "NO_MEDIA_PRESENT" {
+				st.stoppedAt = time.Now()
+				stopped = true
+			}
+		case "TransportStatus":
+			if value != "OK" && value != st.TransportStatus {
+				log.Printf("%s: transport status %s", name, value)
+			}
+			st.TransportStatus = value
+		case "Volume":
+			if value != st.Volume && st.Volume != "" {
+				log.Printf("volume: %s: %s", name, value)
+			}
+			st.Volume = value
+		case "Mute":
+			if value != st.Mute && st.Mute != "" {
+				log.Printf("mute: %s: %s", name, value)
+			}
+			st.Mute = value
+		case "AVTransportURI":
+			if value == st.URI {
+				continue
+			}
+			st.URI = value
+			if value != "" && value != st.ours {
+				log.Printf("%s: playing %s", name, value)
+				switched = true
+			}
+		}
+	}
+	stoppedAt := st.stoppedAt
+	s.evmu.Unlock()
+	// the watchdog plays again instead
+	if s.watchdog {
+		return
+	}
+	switch {
+	case switched:
+		go s.rendererGone(dev, "switched to another source")
+	case stopped:
+		go s.stoppedOnRenderer(dev, stoppedAt)
+	}
+}
+
+// rendererState returns a copy of what the device reported
+func (s *session) rendererState(dev *goupnp.MaybeRootDevice) (rendererState, bool) {
+	s.evmu.Lock()
+	defer s.evmu.Unlock()
+	st, ok := s.states[dev]
+	if !ok {
+		return rendererState{}, false
+	}
+	return *st, true
+}
+
+// stoppedOnRenderer drops the device once it stays stopped
+func (s *session) stoppedOnRenderer(dev *goupnp.MaybeRootDevice, stoppedAt time.Time) {
+	time.Sleep(s.stopAfter)
+	st, ok := s.rendererState(dev)
+	if !ok || !st.stoppedAt.Equal(stoppedAt) {
+		return
+	}
+	if st.TransportState != "STOPPED" && st.TransportState != "NO_MEDIA_PRESENT" {
+		return
+	}
+	s.rendererGone(dev, "stopped on the renderer")
+}
+
+// rendererGone drops the device that isn't playing the stream anymore,
+// the stream is torn down when no device is left
+func (s *session) rendererGone(dev *goupnp.MaybeRootDevice, reason string) {
+	s.mu.Lock()
+	if _, ok := s.active[dev]; !ok || !s.playing {
+		s.mu.Unlock()
+		return
+	}
+	log.Printf("%s: %s", deviceName(dev), reason)
+	subs := s.dropLocked(dev)
+	idle := len(s.active) == 0
+	if idle {
+		s.idleLocked()
+	}
+	daemon, onStop := s.daemon, s.onStop
+	s.mu.Unlock()
+	cancelSubscriptions(subs)
+	if daemon != nil {
+		daemon.stoppedOn(dev)
+	}
+	if idle && onStop != nil {
+		onStop()
+	}
+}
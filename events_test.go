@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/huin/goupnp"
+)
+
+func TestRendererSwitched(t *testing.T) {
+	dev := &goupnp.MaybeRootDevice{Root: &goupnp.RootDevice{}}
+	dev.Root.Device.FriendlyName = "TV"
+	ours := "http://192.168.1.5:9000/token/stream.mp3"
+	stopped := make(chan struct{})
+	sess := &session{
+		active:    map[*goupnp.MaybeRootDevice]avsetup{dev: {device: dev, streamURI: ours}},
+		states:    map[*goupnp.MaybeRootDevice]*rendererState{dev: {ours: ours}},
+		playing:   true,
+		quit:      map[*goupnp.MaybeRootDevice]chan struct{}{dev: make(chan struct{})},
+		stopAfter: time.Hour,
+		onStop:    func() { close(stopped) },
+	}
+
+	// a passing stop waits for stopAfter
+	sess.applyEvent(dev, map[string]string{"TransportState": "STOPPED", "AVTransportURI": ours})
+	sess.applyEvent(dev, map[string]string{"TransportState": "PLAYING"})
+	select {
+	case <-stopped:
+		t.Fatal("stopped right away")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	sess.applyEvent(dev, map[string]string{"AVTransportURI": "http://radio.example/live.mp3"})
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("switching to another uri didn't stop the session")
+	}
+	if sess.playing || len(sess.active) != 0 {
+		t.Fatal("the device is still active")
+	}
+}
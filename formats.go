@@ -0,0 +1,433 @@
+// MIT+NoAI License
+//
+// Copyright (c) 2023 ugjka <ugjka@proton.me>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights///
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// This code may not be used to train artificial intelligence computer models
+// or retrieved by artificial intelligence software or hardware.
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// audioFormat is a stream format preset
+type audioFormat struct {
+	name string
+	// ffmpeg muxer
+	format string
+	mime   string
+	// DLNA.ORG_PN
+	profile  string
+	lossless bool
+	be       bool
+	// mime types renderers advertise for this format
+	mimes []string
+}
+
+// formatPresets are ordered by quality, best first
+var formatPresets = []audioFormat{
+	{
+		name:     "lpcm",
+		format:   "lpcm",
+		profile:  "LPCM",
+		lossless: true,
+		be:       true,
+		mimes:    []string{"audio/l16", "audio/l24"},
+	},
+	{
+		name:     "flac",
+		format:   "flac",
+		mime:     "audio/flac",
+		profile:  "FLAC",
+		lossless: true,
+		mimes:    []string{"audio/flac", "audio/x-flac"},
+	},
+	{
+		name:     "wav",
+		format:   "wav",
+		mime:     "audio/wav",
+		profile:  "WAV",
+		lossless: true,
+		mimes:    []string{"audio/wav", "audio/x-wav", "audio/wave"},
+	},
+	{
+		name:    "aac",
+		format:  "adts",
+		mime:    "audio/aac",
+		profile: "ADTS",
+		mimes:   []string{"audio/aac", "audio/x-aac", "audio/vnd.dlna.adts"},
+	},
+	{
+		name:    "mp3",
+		format:  "mp3",
+		mime:    "audio/mpeg",
+		profile: "MP3",
+		mimes:   []string{"audio/mpeg", "audio/mp3", "audio/x-mpeg"},
+	},
+}
+
+// lpcmle is lpcm in little-endian byte order, renderers don't advertise it
+var lpcmle = audioFormat{
+	name:     "lpcmle",
+	format:   "lpcm",
+	profile:  "LPCM",
+	lossless: true,
+}
+
+func findFormat(name string) (audioFormat, error) {
+	if name == lpcmle.name {
+		return lpcmle, nil
+	}
+	for _, f := range formatPresets {
+		if f.name == name {
+			return f, nil
+		}
+	}
+	return audioFormat{}, fmt.Errorf("%s: unknown format", name)
+}
+
+// useFormat sets up the stream for the given preset
+func (s *stream) useFormat(f audioFormat) {
+	s.format = f.format
+	s.mime = f.mime
+	s.be = f.be
+	if f.format == "lpcm" {
+		s.mime = fmt.Sprintf(
+			"audio/L%d;rate=%d;channels=%d",
+			s.bitdepth, s.samplerate, s.channels,
+		)
+	}
+	if f.lossless {
+		s.bitrate = 0
+	}
+	s.contentfeat.profileName = f.profile
+}
+
+type protocolInfo struct {
+	protocol string
+	network  string
+	// mime type without parameters, lower case
+	mime   string
+	params map[string]string
+	// DLNA.ORG_PN if any
+	profile string
+}
+
+// parseProtocolInfo parses a protocolInfo entry like
+// http-get:*:audio/L16;rate=44100;channels=2:DLNA.ORG_PN=LPCM
+func parseProtocolInfo(entry string) (protocolInfo, error) {
+	fields := strings.SplitN(strings.TrimSpace(entry), ":", 4)
+	if len(fields) != 4 {
+		return protocolInfo{}, fmt.Errorf("%s: bad protocolInfo", entry)
+	}
+	info := protocolInfo{
+		protocol: fields[0],
+		network:  fields[1],
+		params:   make(map[string]string),
+	}
+	mime, params, _ := strings.Cut(fields[2], ";")
+	info.mime = strings.ToLower(strings.TrimSpace(mime))
+	for _, param := range strings.Split(params, ";") {
+		key, value, ok := strings.Cut(param, "=")
+		if ok {
+			info.params[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+		}
+	}
+	for _, extra := range strings.Split(fields[3], ";") {
+		key, value, ok := strings.Cut(extra, "=")
+		if ok && key == "DLNA.ORG_PN" {
+			info.profile = value
+		}
+	}
+	return info, nil
+}
+
+// supports returns the matching protocolInfo if the
+// renderer advertises the format for http streaming
+func (f audioFormat) supports(sink []string) (protocolInfo, bool) {
+	for _, entry := range sink {
+		info, err := parseProtocolInfo(entry)
+		if err != nil {
+			continue
+		}
+		if info.protocol != "http-get" && info.protocol != "*" {
+			continue
+		}
+		if info.mime == "*" || info.mime == "*/*" {
+			return info, true
+		}
+		for _, mime := range f.mimes {
+			if info.mime == mime {
+				return info, true
+			}
+		}
+	}
+	return protocolInfo{}, false
+}
+
+// supportedByAll returns the first renderer's protocolInfo
+// if all the renderers support the format, for lpcm the best
+// sample format they all take
+func supportedByAll(f audioFormat, sinks [][]string, want pcmSpec) (protocolInfo, bool) {
+	if len(sinks) == 0 {
+		return protocolInfo{}, false
+	}
+	if f.name == "lpcm" {
+		return bestLPCM(sinks, want)
+	}
+	var first protocolInfo
+	for i, sink := range sinks {
+		info, ok := f.supports(sink)
+		if !ok {
+			return protocolInfo{}, false
+		}
+		if i == 0 {
+			first = info
+		}
+	}
+	return first, true
+}
+
+// pcmSpec is a raw pcm sample format
+type pcmSpec struct {
+	bits     int
+	rate     int
+	channels int
+}
+
+// lpcmInfo returns the L16/L24 entries the sink takes over http
+func lpcmInfo(sink []string) []protocolInfo {
+	var infos []protocolInfo
+	for _, entry := range sink {
+		info, err := parseProtocolInfo(entry)
+		if err != nil || info.protocol != "http-get" && info.protocol != "*" {
+			continue
+		}
+		switch info.mime {
+		case "audio/l16", "audio/l24", "*", "*/*":
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
+// accepts reports whether the entry takes the sample format,
+// missing parameters take any value
+func accepts(info protocolInfo, spec pcmSpec) bool {
+	switch info.mime {
+	case "*", "*/*":
+		return true
+	case "audio/l16":
+		if spec.bits != 16 {
+			return false
+		}
+	case "audio/l24":
+		if spec.bits != 24 {
+			return false
+		}
+	}
+	if rate, ok := info.params["rate"]; ok && rate != strconv.Itoa(spec.rate) {
+		return false
+	}
+	if channels, ok := info.params["channels"]; ok && channels != strconv.Itoa(spec.channels) {
+		return false
+	}
+	return true
+}
+
+// fill takes the entry's sample format, with the
+// missing parameters and wildcards from want
+func fill(info protocolInfo, want pcmSpec) pcmSpec {
+	spec := want
+	switch info.mime {
+	case "audio/l16":
+		spec.bits = 16
+	case "audio/l24":
+		spec.bits = 24
+	}
+	if rate, err := strconv.Atoi(info.params["rate"]); err == nil {
+		spec.rate = rate
+	}
+	if channels, err := strconv.Atoi(info.params["channels"]); err == nil {
+		spec.channels = channels
+	}
+	return spec
+}
+
+// distance ranks a sample format against the wanted one, 0 is a match.
+// Going above costs a little, going below costs a lot.
+func (spec pcmSpec) distance(want pcmSpec) float64 {
+	cost := func(got, want int) float64 {
+		if want <= 0 {
+			return 0
+		}
+		d := float64(got-want) / float64(want)
+		if d < 0 {
+			return -d * 1000
+		}
+		return d
+	}
+	return cost(spec.rate, want.rate) +
+		cost(spec.bits, want.bits) +
+		cost(spec.channels, want.channels)
+}
+
+// bestLPCM returns the sample format all the renderers take that is
+// closest to want, preferring the ones above it. Renderers often list
+// low rates like audio/L16;rate=8000;channels=1 first.
+func bestLPCM(sinks [][]string, want pcmSpec) (protocolInfo, bool) {
+	var candidates []pcmSpec
+	var firstInfos []protocolInfo
+	for i, sink := range sinks {
+		infos := lpcmInfo(sink)
+		if len(infos) == 0 {
+			return protocolInfo{}, false
+		}
+		if i == 0 {
+			firstInfos = infos
+		}
+		for _, info := range infos {
+			candidates = append(candidates, fill(info, want))
+		}
+	}
+	var (
+		best     pcmSpec
+		bestInfo protocolInfo
+		found    bool
+	)
+	for _, spec := range candidates {
+		if found && spec.distance(want) >= best.distance(want) {
+			continue
+		}
+		all := true
+		for _, sink := range sinks {
+			ok := false
+			for _, info := range lpcmInfo(sink) {
+				if accepts(info, spec) {
+					ok = true
+					break
+				}
+			}
+			all = all && ok
+		}
+		if !all {
+			continue
+		}
+		best, found = spec, true
+		for _, info := range firstInfos {
+			if accepts(info, spec) {
+				bestInfo = info
+				break
+			}
+		}
+	}
+	if !found {
+		return protocolInfo{}, false
+	}
+	// spelled out so that the stream uses exactly this format
+	bestInfo.mime = fmt.Sprintf("audio/l%d", best.bits)
+	bestInfo.params = map[string]string{
+		"rate":     strconv.Itoa(best.rate),
+		"channels": strconv.Itoa(best.channels),
+	}
+	return bestInfo, true
+}
+
+type formatMatch struct {
+	format audioFormat
+	info   protocolInfo
+}
+
+// autoFormats returns the formats all the renderers can play,
+// best first, want is the pcm sample format asked for
+func autoFormats(sinks [][]string, want pcmSpec) []formatMatch {
+	var matches []formatMatch
+	for _, f := range formatPresets {
+		info, ok := supportedByAll(f, sinks, want)
+		if !ok {
+			continue
+		}
+		if info.profile != "" {
+			f.profile = info.profile
+		}
+		matches = append(matches, formatMatch{f, info})
+	}
+	return matches
+}
+
+// formatChain makes a stream for each of the format presets,
+// they share everything with base but the pipeline
+func formatChain(base stream, names []string) ([]stream, error) {
+	var streams []stream
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		f, err := findFormat(name)
+		if err != nil {
+			return nil, err
+		}
+		// lpcm and lpcmle would share the same path
+		if seen[f.format] {
+			return nil, fmt.Errorf("%s: duplicate format", name)
+		}
+		seen[f.format] = true
+		st := base
+		st.pipe = &pipeline{sink: base.pipe.sink}
+		st.useFormat(f)
+		streams = append(streams, st)
+	}
+	if len(streams) == 0 {
+		return nil, fmt.Errorf("no formats given")
+	}
+	return streams, nil
+}
+
+// useProtocolInfo adjusts the pcm parameters to the ones the renderer wants,
+// e.g. audio/L16;rate=48000;channels=2, the other formats take the mime
+// type as the renderer spelled it, e.g. audio/x-flac
+func (s *stream) useProtocolInfo(info protocolInfo) {
+	if s.format != "lpcm" {
+		if info.mime != "*" && info.mime != "*/*" {
+			s.mime = info.mime
+		}
+		return
+	}
+	if bits, ok := strings.CutPrefix(info.mime, "audio/l"); ok {
+		if n, err := strconv.Atoi(bits); err == nil {
+			s.bitdepth = n
+		}
+	}
+	if rate, err := strconv.Atoi(info.params["rate"]); err == nil {
+		s.samplerate = rate
+	}
+	if channels, err := strconv.Atoi(info.params["channels"]); err == nil {
+		s.channels = channels
+	}
+	s.mime = fmt.Sprintf(
+		"audio/L%d;rate=%d;channels=%d",
+		s.bitdepth, s.samplerate, s.channels,
+	)
+}
@@ -0,0 +1,146 @@
+package main
+
+import "testing"
+
+func TestAutoFormat(t *testing.T) {
+	cd := pcmSpec{bits: 16, rate: 44100, channels: 2}
+	tv := []string{
+		"http-get:*:audio/mpeg:DLNA.ORG_PN=MP3",
+		"http-get:*:audio/L16;rate=48000;channels=2:DLNA.ORG_PN=LPCM",
+		"http-get:*:audio/x-flac:*",
+	}
+	speaker := []string{
+		"http-get:*:audio/mpeg:*",
+		"http-get:*:audio/flac:*",
+		"rtsp-rtp-udp:*:audio/L16:*",
+	}
+	radio := []string{
+		"http-get:*:audio/mpeg:*",
+		"http-get:*:audio/vnd.dlna.adts:DLNA.ORG_PN=AAC_ADTS_320",
+	}
+
+	tests := []struct {
+		sinks   [][]string
+		want    string
+		profile string
+	}{
+		{[][]string{tv}, "lpcm", "LPCM"},
+		{[][]string{speaker}, "flac", "FLAC"},
+		{[][]string{tv, speaker}, "flac", "FLAC"},
+		{[][]string{radio}, "aac", "AAC_ADTS_320"},
+		{[][]string{tv, speaker, radio}, "mp3", "MP3"},
+	}
+	for _, test := range tests {
+		matches := autoFormats(test.sinks, cd)
+		if len(matches) == 0 {
+			t.Fatal("no supported format found")
+		}
+		f := matches[0].format
+		if f.name != test.want || f.profile != test.profile {
+			t.Errorf("got %s %s, wanted %s %s",
+				f.name, f.profile, test.want, test.profile)
+		}
+	}
+
+	if matches := autoFormats([][]string{{"http-get:*:video/mp4:*"}}, cd); len(matches) != 0 {
+		t.Error("expected no supported format")
+	}
+
+	s := stream{bitdepth: 16, samplerate: 44100, channels: 2}
+	match := autoFormats([][]string{tv}, cd)[0]
+	s.useFormat(match.format)
+	s.useProtocolInfo(match.info)
+	if s.mime != "audio/L16;rate=48000;channels=2" || !s.be || s.bitrate != 0 {
+		t.Errorf("got %s be=%v, wanted 48kHz big-endian lpcm", s.mime, s.be)
+	}
+
+	// renderers that only know the aliases get them back
+	aliases := []string{
+		"http-get:*:audio/x-flac:*",
+		"http-get:*:audio/vnd.dlna.adts:*",
+		"http-get:*:*:*",
+	}
+	wants := []string{"audio/x-flac", "audio/vnd.dlna.adts", "audio/flac"}
+	for i, entry := range aliases {
+		matches := autoFormats([][]string{{entry}}, cd)
+		var match formatMatch
+		for _, m := range matches {
+			if m.format.name != "lpcm" {
+				match = m
+				break
+			}
+		}
+		s := stream{bitdepth: 16, samplerate: 44100, channels: 2}
+		s.useFormat(match.format)
+		s.useProtocolInfo(match.info)
+		if s.mime != wants[i] {
+			t.Errorf("%s: got %s, wanted %s", entry, s.mime, wants[i])
+		}
+	}
+}
+
+func TestBestLPCM(t *testing.T) {
+	cd := pcmSpec{bits: 16, rate: 44100, channels: 2}
+	tv := []string{
+		"http-get:*:audio/L16;rate=8000;channels=1:DLNA.ORG_PN=LPCM_low",
+		"http-get:*:audio/L16;rate=44100;channels=1:DLNA.ORG_PN=LPCM",
+		"http-get:*:audio/L16;rate=44100;channels=2:DLNA.ORG_PN=LPCM",
+		"http-get:*:audio/L16;rate=48000;channels=2:DLNA.ORG_PN=LPCM",
+		"http-get:*:audio/L24;rate=96000;channels=2:*",
+	}
+	speaker := []string{
+		"http-get:*:audio/L16;rate=8000;channels=1:*",
+		"http-get:*:audio/L16;rate=48000:*",
+	}
+	studio := []string{"http-get:*:audio/L24:*"}
+
+	tests := []struct {
+		sinks [][]string
+		want  pcmSpec
+		mime  string
+	}{
+		// the exact match over the first entry
+		{[][]string{tv}, cd, "audio/L16;rate=44100;channels=2"},
+		// the closest above when there's no exact match
+		{[][]string{tv}, pcmSpec{16, 32000, 2}, "audio/L16;rate=44100;channels=2"},
+		{[][]string{tv}, pcmSpec{24, 88200, 2}, "audio/L24;rate=96000;channels=2"},
+		// every renderer has to take it
+		{[][]string{tv, speaker}, cd, "audio/L16;rate=48000;channels=2"},
+		// missing parameters take the wanted ones
+		{[][]string{studio}, pcmSpec{24, 48000, 2}, "audio/L24;rate=48000;channels=2"},
+	}
+	for _, test := range tests {
+		info, ok := bestLPCM(test.sinks, test.want)
+		if !ok {
+			t.Fatalf("%v: no format found", test.want)
+		}
+		s := stream{format: "lpcm"}
+		s.useProtocolInfo(info)
+		if s.mime != test.mime {
+			t.Errorf("%v: got %s, wanted %s", test.want, s.mime, test.mime)
+		}
+	}
+	if _, ok := bestLPCM([][]string{speaker, studio}, cd); ok {
+		t.Error("L16 and L24 only renderers have no format in common")
+	}
+}
+
+func TestFormatChain(t *testing.T) {
+	base := stream{bitrate: 320, bitdepth: 16, samplerate: 44100, channels: 2, pipe: &pipeline{}}
+	streams, err := formatChain(base, []string{"flac", " wav", "mp3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streams) != 3 || streams[2].bitrate != 320 || streams[0].bitrate != 0 {
+		t.Fatalf("unexpected chain %+v", streams)
+	}
+	if streams[0].pipe == streams[1].pipe || streams[0].pipe == base.pipe {
+		t.Fatal("streams must not share pipelines")
+	}
+	if _, err := formatChain(base, []string{"lpcm", "lpcmle"}); err == nil {
+		t.Fatal("expected duplicate format error")
+	}
+	if _, err := formatChain(base, []string{"ogg"}); err == nil {
+		t.Fatal("expected unknown format error")
+	}
+}
@@ -0,0 +1,226 @@
+// MIT+NoAI License
+//
+// Copyright (c) 2023 ugjka <ugjka@proton.me>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights///
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// This code may not be used to train artificial intelligence computer models
+// or retrieved by artificial intelligence software or hardware.
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/huin/goupnp"
+)
+
+const (
+	// seconds asked for, the subscription is renewed at half time
+	GENA_TIMEOUT = 300
+	// wait before subscribing again after a failed renewal
+	GENA_RETRY = 30 * time.Second
+)
+
+// subscription is a GENA event subscription to a service of a renderer
+type subscription struct {
+	dev *goupnp.MaybeRootDevice
+	// short service name, e.g. AVTransport
+	service  string
+	url      string
+	callback string
+	mu       sync.Mutex
+	sid      string
+	quit     chan struct{}
+}
+
+func newSubscription(dev *goupnp.MaybeRootDevice, urn, callback string) (*subscription, error) {
+	services := dev.Root.Device.FindService(urn)
+	if len(services) == 0 || !services[0].EventSubURL.Ok {
+		return nil, fmt.Errorf("%s: no event url", urn)
+	}
+	parts := strings.Split(urn, ":")
+	return &subscription{
+		dev:      dev,
+		service:  parts[len(parts)-2],
+		url:      services[0].EventSubURL.URL.String(),
+		callback: callback,
+		quit:     make(chan struct{}),
+	}, nil
+}
+
+// start subscribes and keeps the subscription alive until cancelled
+func (sub *subscription) start() error {
+	timeout, err := sub.send()
+	if err != nil {
+		return err
+	}
+	go sub.renew(timeout)
+	return nil
+}
+
+func (sub *subscription) renew(timeout time.Duration) {
+	for {
+		select {
+		case <-sub.quit:
+			return
+		case <-time.After(timeout / 2):
+		}
+		var err error
+		timeout, err = sub.send()
+		if err == nil {
+			continue
+		}
+		// the renderer may have forgotten us, start over
+		log.Printf("events: %s: %s: %v", deviceName(sub.dev), sub.service, err)
+		sub.mu.Lock()
+		sub.sid = ""
+		sub.mu.Unlock()
+		timeout, err = sub.send()
+		if err != nil {
+			timeout = 2 * GENA_RETRY
+		}
+	}
+}
+
+// send subscribes, or renews the subscription once there's a sid
+func (sub *subscription) send() (time.Duration, error) {
+	header := map[string]string{
+		"TIMEOUT": fmt.Sprintf("Second-%d", GENA_TIMEOUT),
+	}
+	if sid := sub.currentSID(); sid != "" {
+		header["SID"] = sid
+	} else {
+		header["CALLBACK"] = "<" + sub.callback + ">"
+		header["NT"] = "upnp:event"
+	}
+	resp, err := sub.request("SUBSCRIBE", header)
+	if err != nil {
+		return 0, err
+	}
+	sid := resp.Header.Get("SID")
+	if sid == "" {
+		return 0, fmt.Errorf("no SID in the reply")
+	}
+	sub.mu.Lock()
+	sub.sid = sid
+	sub.mu.Unlock()
+	return parseTimeout(resp.Header.Get("TIMEOUT")), nil
+}
+
+func (sub *subscription) currentSID() string {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.sid
+}
+
+// cancel stops renewing and unsubscribes
+func (sub *subscription) cancel() {
+	close(sub.quit)
+	if sid := sub.currentSID(); sid != "" {
+		sub.request("UNSUBSCRIBE", map[string]string{"SID": sid})
+	}
+}
+
+func (sub *subscription) request(method string, header map[string]string) (*http.Response, error) {
+	req, err := http.NewRequest(method, sub.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range header {
+		// as is, some renderers want the upper case names
+		req.Header[key] = []string{value}
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", strings.ToLower(method), resp.Status)
+	}
+	return resp, nil
+}
+
+// parseTimeout parses e.g. Second-1800, infinite gets the default
+func parseTimeout(timeout string) time.Duration {
+	secs, err := strconv.Atoi(strings.TrimPrefix(strings.ToLower(timeout), "second-"))
+	if err != nil || secs <= 0 {
+		return GENA_TIMEOUT * time.Second
+	}
+	return time.Duration(secs) * time.Second
+}
+
+type propertySet struct {
+	Properties []struct {
+		LastChange string `xml:"LastChange"`
+	} `xml:"property"`
+}
+
+type lastChange struct {
+	Instances []struct {
+		ID   string `xml:"val,attr"`
+		Vars []struct {
+			XMLName xml.Name
+			Val     string `xml:"val,attr"`
+			Channel string `xml:"channel,attr"`
+		} `xml:",any"`
+	} `xml:"InstanceID"`
+}
+
+// parseEvent returns the changed state variables of
+// instance 0 from the LastChange of an event
+func parseEvent(body io.Reader) (map[string]string, error) {
+	var props propertySet
+	err := xml.NewDecoder(body).Decode(&props)
+	if err != nil {
+		return nil, err
+	}
+	changes := make(map[string]string)
+	for _, prop := range props.Properties {
+		if prop.LastChange == "" {
+			continue
+		}
+		var event lastChange
+		err := xml.Unmarshal([]byte(prop.LastChange), &event)
+		if err != nil {
+			return nil, fmt.Errorf("last change: %v", err)
+		}
+		for _, instance := range event.Instances {
+			if instance.ID != "0" {
+				continue
+			}
+			for _, v := range instance.Vars {
+				if v.Channel != "" && v.Channel != "Master" {
+					continue
+				}
+				changes[v.XMLName.Local] = v.Val
+			}
+		}
+	}
+	return changes, nil
+}
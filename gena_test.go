@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/huin/goupnp"
+	"github.com/huin/goupnp/dcps/av1"
+)
+
+const avtEvent = `<?xml version="1.0"?>
+<e:propertyset xmlns:e="urn:schemas-upnp-org:event-1-0">
+<e:property>
+<LastChange>&lt;Event xmlns=&quot;urn:schemas-upnp-org:metadata-1-0/AVT/&quot;&gt;
+&lt;InstanceID val=&quot;0&quot;&gt;
+&lt;TransportState val=&quot;PAUSED_PLAYBACK&quot;/&gt;
+&lt;AVTransportURI val=&quot;http://192.168.1.5:9000/stream.mp3&quot;/&gt;
+&lt;Volume channel=&quot;LF&quot; val=&quot;3&quot;/&gt;
+&lt;Volume channel=&quot;Master&quot; val=&quot;25&quot;/&gt;
+&lt;/InstanceID&gt;
+&lt;InstanceID val=&quot;1&quot;&gt;&lt;TransportState val=&quot;STOPPED&quot;/&gt;&lt;/InstanceID&gt;
+&lt;/Event&gt;</LastChange>
+</e:property>
+</e:propertyset>`
+
+func TestParseEvent(t *testing.T) {
+	changes, err := parseEvent(strings.NewReader(avtEvent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"TransportState": "PAUSED_PLAYBACK",
+		"AVTransportURI": "http://192.168.1.5:9000/stream.mp3",
+		"Volume":         "25",
+	}
+	for name, value := range want {
+		if changes[name] != value {
+			t.Errorf("%s: got %q, wanted %q", name, changes[name], value)
+		}
+	}
+	if len(changes) != len(want) {
+		t.Errorf("got %v", changes)
+	}
+}
+
+func TestSubscription(t *testing.T) {
+	var methods []string
+	renderer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		switch r.Method {
+		case "SUBSCRIBE":
+			if r.Header.Get("CALLBACK") != "<http://192.168.1.5:9000/events/1>" {
+				t.Errorf("bad callback %q", r.Header.Get("CALLBACK"))
+			}
+			w.Header().Set("SID", "uuid:sub-1")
+			w.Header().Set("TIMEOUT", "Second-1800")
+		case "UNSUBSCRIBE":
+			if r.Header.Get("SID") != "uuid:sub-1" {
+				t.Errorf("bad sid %q", r.Header.Get("SID"))
+			}
+		}
+	}))
+	defer renderer.Close()
+
+	base, _ := url.Parse(renderer.URL)
+	root := &goupnp.RootDevice{}
+	root.Device.Services = []goupnp.Service{{
+		ServiceType: av1.URN_AVTransport_1,
+		EventSubURL: goupnp.URLField{Str: "/AVTransport/event"},
+	}}
+	root.SetURLBase(base)
+	dev := &goupnp.MaybeRootDevice{Root: root, Location: base}
+
+	sub, err := newSubscription(dev, av1.URN_AVTransport_1, "http://192.168.1.5:9000/events/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.service != "AVTransport" {
+		t.Errorf("service is %s", sub.service)
+	}
+	if err := sub.start(); err != nil {
+		t.Fatal(err)
+	}
+	if sub.currentSID() != "uuid:sub-1" {
+		t.Fatalf("sid is %q", sub.currentSID())
+	}
+	sub.cancel()
+	if strings.Join(methods, ",") != "SUBSCRIBE,UNSUBSCRIBE" {
+		t.Fatalf("got %v", methods)
+	}
+}
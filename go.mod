@@ -3,7 +3,9 @@ module blast
 go 1.18
 
 require (
+	github.com/BurntSushi/toml v1.4.0
 	github.com/davecgh/go-spew v1.1.1
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/huin/goupnp v1.3.0
 )
 
@@ -0,0 +1,79 @@
+// MIT+NoAI License
+//
+// Copyright (c) 2023 ugjka <ugjka@proton.me>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights///
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// This code may not be used to train artificial intelligence computer models
+// or retrieved by artificial intelligence software or hardware.
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"os/exec"
+)
+
+// hotkeys puts the terminal in non-canonical mode and reads single
+// key presses for controlling the renderers' volume. The returned
+// function restores the terminal.
+func hotkeys(sess *session) (restore func()) {
+	restore = func() {}
+	stat, err := os.Stdin.Stat()
+	if err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+		return
+	}
+	saveCMD := exec.Command("stty", "-g")
+	saveCMD.Stdin = os.Stdin
+	saved, err := saveCMD.Output()
+	if err != nil {
+		return
+	}
+	rawCMD := exec.Command("stty", "-icanon", "-echo", "min", "1")
+	rawCMD.Stdin = os.Stdin
+	if rawCMD.Run() != nil {
+		return
+	}
+	restore = func() {
+		restoreCMD := exec.Command("stty", string(bytes.TrimSpace(saved)))
+		restoreCMD.Stdin = os.Stdin
+		restoreCMD.Run()
+	}
+
+	log.Println("press + or - to change the volume, m to toggle mute")
+	go func() {
+		key := make([]byte, 1)
+		for {
+			_, err := os.Stdin.Read(key)
+			if err != nil {
+				return
+			}
+			switch key[0] {
+			case '+', '=':
+				sess.changeVolume(VOLUME_STEP)
+			case '-', '_':
+				sess.changeVolume(-VOLUME_STEP)
+			case 'm', 'M':
+				sess.toggleMute()
+			}
+		}
+	}()
+	return
+}
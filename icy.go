@@ -0,0 +1,101 @@
+// MIT+NoAI License
+//
+// Copyright (c) 2023 ugjka <ugjka@proton.me>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights///
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// This code may not be used to train artificial intelligence computer models
+// or retrieved by artificial intelligence software or hardware.
+package main
+
+import (
+	"io"
+)
+
+const (
+	// audio bytes between the metadata blocks
+	ICY_METAINT = 16000
+	ICY_NAME    = "Blast"
+)
+
+// icyWriter interleaves shoutcast metadata blocks into the audio
+type icyWriter struct {
+	w    io.Writer
+	meta *metadata
+	// audio bytes left until the next block
+	left int
+	// the title in the last block
+	sent string
+}
+
+func newIcyWriter(w io.Writer, meta *metadata) *icyWriter {
+	return &icyWriter{w: w, meta: meta, left: ICY_METAINT}
+}
+
+func (iw *icyWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		n := len(p)
+		if n > iw.left {
+			n = iw.left
+		}
+		n, err := iw.w.Write(p[:n])
+		written += n
+		iw.left -= n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+		if iw.left > 0 {
+			continue
+		}
+		iw.left = ICY_METAINT
+		title := iw.meta.get().streamTitle()
+		block := []byte{0}
+		// an empty block means the title didn't change
+		if title != iw.sent {
+			block = icyBlock(title)
+			iw.sent = title
+		}
+		if _, err := iw.w.Write(block); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// icyBlock returns the metadata block: its length in 16 byte units
+// followed by the zero padded text
+func icyBlock(title string) []byte {
+	text := "StreamTitle='" + title + "';"
+	// the length byte can't count more than 255 units
+	if len(text) > 255*16 {
+		text = text[:255*16-2] + "';"
+	}
+	units := (len(text) + 15) / 16
+	block := make([]byte, 1+units*16)
+	block[0] = byte(units)
+	copy(block[1:], text)
+	return block
+}
+
+// icy reports whether the format can carry interleaved metadata
+func (s stream) icy() bool {
+	return s.meta != nil && (s.format == "mp3" || s.format == "adts")
+}
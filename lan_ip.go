@@ -25,28 +25,21 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/huin/goupnp"
 )
 
 func chooseStreamIP(lookup string) (net.IP, error) {
-	addrs, err := net.InterfaceAddrs()
+	ips, err := listStreamIPs()
 	if err != nil {
 		return nil, err
 	}
-
-	ips := make([]net.IP, 0)
-	for _, addr := range addrs {
-		if ipnet, ok := addr.(*net.IPNet); ok &&
-			!ipnet.IP.IsLoopback() &&
-			(ipnet.IP.To4() != nil || ipnet.IP.To16() != nil) {
-			ips = append(ips, ipnet.IP)
-		}
-	}
-
-	if len(ips) == 0 {
-		return nil, fmt.Errorf("no usable lan ip addresses found")
-	}
 	if lookup != "" {
 		lookupIp := net.ParseIP(lookup)
 		if lookupIp == nil {
@@ -71,6 +64,73 @@ func chooseStreamIP(lookup string) (net.IP, error) {
 	return ips[selected], nil
 }
 
+// routeStreamIP finds the local address that reaches all the devices,
+// from the source address the kernel picks for the route to them
+func routeStreamIP(devices []*goupnp.MaybeRootDevice) (net.IP, error) {
+	ips, err := listStreamIPs()
+	if err != nil {
+		return nil, err
+	}
+	var routed net.IP
+	var routes []string
+	for _, dev := range devices {
+		ip, err := routeIP(dev.Location.Hostname(), dev.Location.Port())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", deviceName(dev), err)
+		}
+		routes = append(routes, fmt.Sprintf("%s via %s", deviceName(dev), ip))
+		if routed != nil && !routed.Equal(ip) {
+			return nil, fmt.Errorf("the devices are reached from different addresses: %s",
+				strings.Join(routes, ", "))
+		}
+		routed = ip
+	}
+	if routed == nil {
+		return nil, fmt.Errorf("no devices to route to")
+	}
+	for _, ip := range ips {
+		if ip.Equal(routed) {
+			return routed, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: not a lan ip address", routed)
+}
+
+// routeIP returns the local address used to reach the host
+func routeIP(host, port string) (net.IP, error) {
+	if port == "" {
+		port = "80"
+	}
+	// connecting udp sends nothing, it only picks the route
+	conn, err := net.Dial("udp", net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+func listStreamIPs() ([]net.IP, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	ips := make([]net.IP, 0)
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok &&
+			!ipnet.IP.IsLoopback() &&
+			(ipnet.IP.To4() != nil || ipnet.IP.To16() != nil) {
+			ips = append(ips, ipnet.IP)
+		}
+	}
+
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no usable lan ip addresses found")
+	}
+	return ips, nil
+}
+
 func findInterface(ip net.IP) (string, error) {
 	infs, err := net.Interfaces()
 	if err != nil {
@@ -89,3 +149,67 @@ func findInterface(ip net.IP) (string, error) {
 	}
 	return "", fmt.Errorf("no interface found for ip")
 }
+
+// remoteIP returns the ip address of a host:port pair without the ipv6 zone
+func remoteIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	host, _, _ = strings.Cut(host, "%")
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return host
+}
+
+// lookupHost resolves a host name to an ip address
+func lookupHost(host string) string {
+	host, _, _ = strings.Cut(host, "%")
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil || len(ips) == 0 {
+		return host
+	}
+	return ips[0].String()
+}
+
+// parsePorts parses a port, 0 for any free port, or a range like 9000-9010
+func parsePorts(ports string) (first, last int, err error) {
+	from, to, isRange := strings.Cut(ports, "-")
+	first, err = strconv.Atoi(strings.TrimSpace(from))
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: bad port", ports)
+	}
+	last = first
+	if isRange {
+		last, err = strconv.Atoi(strings.TrimSpace(to))
+		if err != nil {
+			return 0, 0, fmt.Errorf("%s: bad port range", ports)
+		}
+	}
+	if first < 0 || last > 65535 || first > last {
+		return 0, 0, fmt.Errorf("%s: bad port range", ports)
+	}
+	return first, last, nil
+}
+
+// listenPorts listens on the first free port of the range
+func listenPorts(host string, ports string) (net.Listener, error) {
+	first, last, err := parsePorts(ports)
+	if err != nil {
+		return nil, err
+	}
+	for port := first; port <= last; port++ {
+		listener, err := net.Listen("tcp", net.JoinHostPort(host, fmt.Sprint(port)))
+		if err == nil {
+			return listener, nil
+		}
+		if port == last || !errors.Is(err, syscall.EADDRINUSE) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("no free port in %s", ports)
+}
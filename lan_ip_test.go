@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/huin/goupnp"
+)
+
+func TestParsePorts(t *testing.T) {
+	tests := map[string][2]int{
+		"9000":      {9000, 9000},
+		"0":         {0, 0},
+		"9000-9010": {9000, 9010},
+	}
+	for ports, want := range tests {
+		first, last, err := parsePorts(ports)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first != want[0] || last != want[1] {
+			t.Errorf("%s: got %d-%d, wanted %d-%d", ports, first, last, want[0], want[1])
+		}
+	}
+	for _, bad := range []string{"", "http", "9010-9000", "9000-70000"} {
+		if _, _, err := parsePorts(bad); err == nil {
+			t.Errorf("%q should fail", bad)
+		}
+	}
+}
+
+func TestListenPortsSkipsBusy(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	port := busy.Addr().(*net.TCPAddr).Port
+	listener, err := listenPorts("127.0.0.1", fmt.Sprintf("%d-%d", port, port+5))
+	if err != nil {
+		t.Skip(err)
+	}
+	defer listener.Close()
+	if listener.Addr().(*net.TCPAddr).Port == port {
+		t.Fatal("listened on the busy port")
+	}
+}
+
+func TestRouteStreamIP(t *testing.T) {
+	ips, err := listStreamIPs()
+	if err != nil {
+		t.Skip(err)
+	}
+	// a made up renderer next to the first address routes through it
+	var first net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil && !ip.IsLinkLocalUnicast() {
+			first = ip.To4()
+			break
+		}
+	}
+	if first == nil {
+		t.Skip("no ipv4 lan address")
+	}
+	neighbour := net.IPv4(first[0], first[1], first[2], first[3]^1)
+	location, _ := url.Parse("http://" + neighbour.String() + ":1400/desc.xml")
+	routed, err := routeStreamIP([]*goupnp.MaybeRootDevice{{Location: location}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !routed.Equal(first) {
+		t.Fatalf("routed through %s, wanted %s", routed, first)
+	}
+
+	// loopback isn't a lan address to stream from
+	location, _ = url.Parse("http://127.0.0.1:1400/desc.xml")
+	if _, err := routeStreamIP([]*goupnp.MaybeRootDevice{{Location: location}}); err == nil {
+		t.Fatal("routing to loopback should fail")
+	}
+}
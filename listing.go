@@ -0,0 +1,148 @@
+// MIT+NoAI License
+//
+// Copyright (c) 2023 ugjka <ugjka@proton.me>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights///
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// This code may not be used to train artificial intelligence computer models
+// or retrieved by artificial intelligence software or hardware.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/huin/goupnp/dcps/av1"
+)
+
+type deviceInfo struct {
+	FriendlyName  string   `json:"friendly_name"`
+	UDN           string   `json:"udn"`
+	Manufacturer  string   `json:"manufacturer"`
+	Model         string   `json:"model"`
+	Location      string   `json:"location"`
+	AVTransport   int      `json:"avtransport"`
+	SinkProtocols []string `json:"sink_protocols"`
+	Error         string   `json:"error,omitempty"`
+}
+
+type ipInfo struct {
+	IP        string `json:"ip"`
+	Interface string `json:"interface"`
+}
+
+func listDevices(asJSON bool) error {
+	roots, err := discoverDevices()
+	if err != nil {
+		return err
+	}
+	devices := make([]deviceInfo, 0, len(roots))
+	for i := range roots {
+		dev := &roots[i]
+		info := deviceInfo{
+			Location:      dev.Location.String(),
+			SinkProtocols: []string{},
+		}
+		if dev.Root == nil {
+			info.Error = fmt.Sprint(dev.Err)
+			devices = append(devices, info)
+			continue
+		}
+		info.FriendlyName = dev.Root.Device.FriendlyName
+		info.UDN = dev.Root.Device.UDN
+		info.Manufacturer = dev.Root.Device.Manufacturer
+		info.Model = dev.Root.Device.ModelName
+		switch detectAVtransport(dev) {
+		case av1.URN_AVTransport_1:
+			info.AVTransport = 1
+		case av1.URN_AVTransport_2:
+			info.AVTransport = 2
+		}
+		protocols, err := CMSinkProtocols(dev)
+		if err != nil {
+			info.Error = err.Error()
+		} else {
+			info.SinkProtocols = protocols
+		}
+		devices = append(devices, info)
+	}
+	if asJSON {
+		return printJSON(devices)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	fmt.Fprintln(w, "NAME\tUDN\tMANUFACTURER\tMODEL\tAVTRANSPORT\tLOCATION")
+	for _, info := range devices {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n",
+			info.FriendlyName, info.UDN, info.Manufacturer,
+			info.Model, info.AVTransport, info.Location)
+		for _, protocol := range info.SinkProtocols {
+			fmt.Fprintf(w, "\t%s\n", protocol)
+		}
+		if info.Error != "" {
+			fmt.Fprintf(w, "\terror: %s\n", info.Error)
+		}
+	}
+	return w.Flush()
+}
+
+func listSources(asJSON bool) error {
+	sources, err := listAudioSources()
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		return printJSON(sources)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	fmt.Fprintln(w, "NAME\tDRIVER\tSAMPLESPEC\tSTATE")
+	for _, source := range sources {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+			source.Name, source.Driver, source.SampleSpec, source.State)
+	}
+	return w.Flush()
+}
+
+func listIPs(asJSON bool) error {
+	ips, err := listStreamIPs()
+	if err != nil {
+		return err
+	}
+	infos := make([]ipInfo, 0, len(ips))
+	for _, ip := range ips {
+		ifname, _ := findInterface(ip)
+		infos = append(infos, ipInfo{IP: ip.String(), Interface: ifname})
+	}
+	if asJSON {
+		return printJSON(infos)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	fmt.Fprintln(w, "IP\tINTERFACE")
+	for _, info := range infos {
+		fmt.Fprintf(w, "%s\t%s\n", info.IP, info.Interface)
+	}
+	return w.Flush()
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -25,8 +25,8 @@
 package main
 
 import (
-	"bytes"
 	_ "embed"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -38,6 +38,7 @@ import (
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/huin/goupnp"
@@ -48,6 +49,8 @@ const (
 	BLASTMONITOR = "blast.monitor"
 	LOGO_PATH    = "logo.png"
 	VERSION      = "v0.7.0"
+	// exit code when the renderers stopped playing
+	EXIT_STOPPED = 3
 )
 
 //go:embed logo.png
@@ -56,22 +59,11 @@ var logobytes []byte
 var logblast = new(bool)
 
 func main() {
-	// check for dependencies
-	exes := []string{
-		"pactl",
-		"parec",
-		"ffmpeg",
-	}
-	for _, exe := range exes {
-		if _, err := exec.LookPath(exe); err != nil {
-			fmt.Fprintln(os.Stderr, "dependency:", err)
-			os.Exit(1)
-		}
-	}
-	device := flag.String("device", "", "dlna device's friendly name")
-	source := flag.String("source", "", "audio source (pactl list sources short | cut -f2)")
+	device := flag.String("device", "", "dlna device's friendly name, UDN, ip address or re:regex, comma separated for multiple devices")
+	deviceURL := flag.String("device-url", "", "load the dlna device from its description url and skip discovery, comma separated for multiple devices")
+	source := flag.String("source", "", "audio source: pulse source name, alsa:device, fifo:path, file:path or stdin")
 	ip := flag.String("ip", "", "host ip address")
-	port := flag.Int("port", 9000, "stream port")
+	port := flag.String("port", "9000", "stream port, 0 for any free port or a range like 9000-9010")
 	chunk := flag.Int("chunk", 1, "chunk size in seconds")
 	bitrate := flag.Int("bitrate", 320, "audio format bitrate")
 	format := flag.String("format", "mp3", "stream audio format")
@@ -81,28 +73,140 @@ func main() {
 	uselpcm := flag.Bool("uselpcm", false, "use lpcm audio")
 	uselpcmle := flag.Bool("uselpcmle", false, "use lpcm little-endian audio")
 	usewav := flag.Bool("usewav", false, "use wav audio")
+	auto := flag.Bool("auto", false, "pick the best format the renderer supports")
+	formats := flag.String("formats", "", "formats to try in order, e.g. flac,wav,mp3")
+	timeout := flag.Duration("format-timeout", 10*time.Second, "move to the next format if the renderer doesn't request the stream in time")
 	bits := flag.Int("bits", 16, "audio bitdepth")
 	rate := flag.Int("rate", 44100, "audio sample rate")
 	channels := flag.Int("channels", 2, "audio channels")
 	dummy := flag.Bool("dummy", false, "only serve content")
 	debug := flag.Bool("debug", false, "print debug info")
 	headers := flag.Bool("headers", false, "print request headers")
-	logblast = flag.Bool("log", false, "log parec and ffmpeg stderr")
+	logblast = flag.Bool("log", false, "log recorder and ffmpeg stderr")
+	backend := flag.String("pulse", "auto", "talk to pulseaudio natively, with pactl and parec (exec) or auto")
 	nochunked := flag.Bool("nochunked", false, "disable chunked tranfer endcoding")
+	volume := flag.Int("volume", -1, "set the renderer volume (0-100)")
+	control := flag.String("control", "", "serve the control api on this unix socket")
+	exitOnStop := flag.Bool("exit-on-stop", false, "exit when the renderers stop playing the stream or switch to another source")
+	daemonMode := flag.Bool("daemon", false, "keep running and cast to the -device renderers whenever they appear on the network")
+	stopAfter := flag.Duration("stop-after", 10*time.Second, "how long a renderer has to stay stopped to count as stopped")
+	watch := flag.Bool("watchdog", false, "play again when a renderer drops the stream")
 	version := flag.Bool("version", false, "show blast version")
+	configPath := flag.String("config", defaultConfigPath(), "config file with defaults and device profiles")
+	metaFrom := flag.String("metadata", "auto", "now playing info from mpris, pulse, auto or off")
+	listen := flag.String("listen", "", "listen on this address instead of the stream ip, e.g. [::]:9000 for dual-stack")
+	allow := flag.String("allow", "", "also let these networks fetch the stream, comma separated, e.g. 192.168.1.0/24")
+	useTLS := flag.Bool("tls", false, "serve the stream over https, with a self-signed certificate unless -tls-cert and -tls-key are given")
+	tlsCert := flag.String("tls-cert", "", "https certificate file")
+	tlsKey := flag.String("tls-key", "", "https private key file")
+	title := flag.String("title", "", "fixed stream title for the renderers and icy clients (default: now playing info)")
+	metaReset := flag.Bool("metadata-reset", false, "set the stream URI again on title changes, for renderers that ignore SetNextAVTransportURI")
+	protocol := flag.String("protocol", "", "stream URI protocol, e.g. x-rincon-mp3radio (default: detect Sonos)")
+	discoverTimeout := flag.Duration("discover-timeout", 2*time.Second, "how long to wait for the renderers to answer a search")
+	discoverTargets := flag.String("discover-targets", "AVTransport:1,AVTransport:2", "ssdp search targets, comma separated: AVTransport:1, AVTransport:2, MediaRenderer:1, MediaRenderer:2, ssdp:all or an urn")
+	discoverIface := flag.String("discover-interface", "", "search from this network interface only (default: all)")
+	discoverPasses := flag.Int("discover-passes", 1, "search this many times, for renderers that miss a search")
+	discoverProbe := flag.String("discover-probe", "", "also search these hosts over unicast, comma separated, e.g. 10.2.0.15")
+	listdevices := flag.Bool("list-devices", false, "list dlna devices and exit")
+	listsources := flag.Bool("list-sources", false, "list audio sources and exit")
+	listips := flag.Bool("list-ips", false, "list lan ip addresses and exit")
+	asJSON := flag.Bool("json", false, "print the lists as json")
 
 	flag.Parse()
 
+	// flags given on the command line win over the config file
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	cfg, err := loadConfig(*configPath, given["config"])
+	if err == nil {
+		err = cfg.applyDefaults(flag.CommandLine, given)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "config:", err)
+		os.Exit(1)
+	}
+
 	if *version {
 		fmt.Fprintln(os.Stderr, VERSION)
 		os.Exit(0)
 	}
 
+	pulse, err = choosePulseBackend(*backend)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "pulse:", err)
+		os.Exit(1)
+	}
+
+	discover, err = newDiscovery(
+		*discoverTimeout,
+		*discoverTargets,
+		*discoverIface,
+		*discoverPasses,
+		*discoverProbe,
+	)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "discover:", err)
+		os.Exit(1)
+	}
+
+	if *listdevices || *listsources || *listips {
+		list := func(name string, enabled bool, fn func(bool) error) {
+			if !enabled {
+				return
+			}
+			if err := fn(*asJSON); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+				os.Exit(1)
+			}
+		}
+		list("upnp", *listdevices, listDevices)
+		list("audio", *listsources, listSources)
+		list("network", *listips, listIPs)
+		os.Exit(0)
+	}
+
+	// check for dependencies, ffmpeg is checked
+	// once the stream formats are known
+	for _, exe := range captureDeps(*source) {
+		if _, err := exec.LookPath(exe); err != nil {
+			fmt.Fprintln(os.Stderr, "dependency:", err)
+			os.Exit(1)
+		}
+	}
+
+	if *daemonMode && (*dummy || *device == "") {
+		fmt.Fprintln(os.Stderr, "daemon: needs -device and can't be used with -dummy")
+		os.Exit(1)
+	}
+	if *exitOnStop && *watch {
+		fmt.Fprintln(os.Stderr, "exit-on-stop: can't be used with -watchdog, which plays again instead")
+		os.Exit(1)
+	}
+	if *deviceURL != "" && (*device != "" || *daemonMode) {
+		fmt.Fprintln(os.Stderr, "upnp: -device-url can't be used with -device or -daemon")
+		os.Exit(1)
+	}
+
 	var (
-		blastSinkID []byte
-		isPlaying   bool
-		DLNADevice  *goupnp.MaybeRootDevice
-		err         error
+		DLNADevices []*goupnp.MaybeRootDevice
+		// renderers come and go in daemon mode
+		netWatch *daemon
+		// restores the terminal from hotkey mode
+		restoreTerm = func() {}
+		sess        = &session{
+			volume:    *volume,
+			protocol:  *protocol,
+			watchdog:  *watch,
+			stopAfter: *stopAfter,
+			meta:      &metadata{},
+			subs:      make(map[string]*subscription),
+			states:    make(map[*goupnp.MaybeRootDevice]*rendererState),
+			started:   time.Now(),
+			cfg:       cfg,
+			given:     given,
+		}
 	)
 
 	// trap ctrl+c and kill and terminal hang up
@@ -110,72 +214,84 @@ func main() {
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
 	cleanup := func() {
-		if blastSinkID != nil {
-			log.Println("unloading the blast sink")
-			exec.Command("pactl", "unload-module", string(blastSinkID)).Run()
+		restoreTerm()
+		sess.unloadBlastSink()
+		if *control != "" {
+			os.Remove(*control)
 		}
 	}
 
-	go func() {
-		<-sig
-		fmt.Println()
+	shutdown := func() {
 		cleanup()
-		if isPlaying && !*dummy {
+		if !*dummy {
 			log.Println("stopping avtransport and exiting")
-			AVStop(DLNADevice)
+			sess.stop()
 		}
 		fmt.Println("terminated...")
 		os.Exit(0)
+	}
+
+	go func() {
+		<-sig
+		fmt.Println()
+		shutdown()
 	}()
-	if !*dummy {
-		DLNADevice, err = chooseUPNPDevice(*device)
+	switch {
+	case *daemonMode:
+		// the renderers don't have to be there yet
+		netWatch, err = newDaemon(*device)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "upnp:", err)
+			os.Exit(1)
+		}
+		DLNADevices = netWatch.devices()
+	case *deviceURL != "" && !*dummy:
+		// for networks where ssdp doesn't get through
+		DLNADevices, err = loadUPNPDevices(*deviceURL)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "upnp:", err)
+			os.Exit(1)
+		}
+	case !*dummy:
+		DLNADevices, err = chooseUPNPDevices(*device)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, "upnp:", err)
 			os.Exit(1)
 		}
 	}
 
-	if *debug {
-		spew.Fdump(os.Stderr, DLNADevice)
-		var location string
-		urn := detectAVtransport(DLNADevice)
-		switch {
-		case urn == av1.URN_AVTransport_1:
-			clients, err := av1.NewAVTransport1ClientsByURL(DLNADevice.Location)
-			if err == nil {
-				location = clients[0].Location.String()
-			}
-			spew.Fdump(os.Stderr, clients, err)
-
-		case urn == av1.URN_AVTransport_2:
-			clients, err := av1.NewAVTransport2ClientsByURL(DLNADevice.Location)
-			if err == nil {
-				location = clients[0].Location.String()
+	// the stream settings come from the first device found at startup
+	// with a profile, the volume and protocol are set for each device
+	for _, dev := range DLNADevices {
+		name, profile, ok := cfg.profile(dev)
+		if !ok {
+			continue
+		}
+		log.Printf("%s: using profile %s", deviceName(dev), name)
+		options := make(map[string]any)
+		for key, value := range profile {
+			if key != "volume" && key != "protocol" {
+				options[key] = value
 			}
-			spew.Fdump(os.Stderr, clients, err)
 		}
+		err = setFlags(flag.CommandLine, given, options, "profile "+name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "config:", err)
+			os.Exit(1)
+		}
+		break
+	}
 
-		get := func() {
-			if location == "" {
-				return
-			}
-			resp, err := http.Get(location)
-			if err != nil {
-				return
-			}
-			data, err := io.ReadAll(resp.Body)
-			if err != nil {
-				return
-			}
-			spew.Fprintln(os.Stderr, string(data))
+	if *debug {
+		for _, DLNADevice := range DLNADevices {
+			debugDevice(DLNADevice)
 		}
-		get()
 
 		if !*headers {
 			os.Exit(0)
 		}
 	}
-	if *device == "" {
+	if *device == "" && *deviceURL == "" {
 		fmt.Println("----------")
 	}
 
@@ -185,22 +301,25 @@ func main() {
 		os.Exit(1)
 	}
 	// on-demand handling of blast sink
-	if sink == BLASTMONITOR {
-		blastSink := exec.Command(
-			"pactl", "load-module", "module-null-sink", "sink_name=blast",
-		)
-		var err error
-		blastSinkID, err = blastSink.Output()
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "blast sink:", err)
-			os.Exit(1)
-		}
-		blastSinkID = bytes.TrimSpace(blastSinkID)
+	err = sess.useSource(sink)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
 	if *source == "" {
 		fmt.Println("----------")
 	}
+	// the address that routes to the renderers, ask only if it's ambiguous
+	if *ip == "" && len(DLNADevices) > 0 {
+		routed, err := routeStreamIP(DLNADevices)
+		if err == nil {
+			log.Printf("network: using %s, it reaches the renderers", routed)
+			*ip = routed.String()
+		} else {
+			log.Printf("network: %v", err)
+		}
+	}
 	streamHost, err := chooseStreamIP(*ip)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "network:", err)
@@ -211,13 +330,7 @@ func main() {
 		fmt.Println("----------")
 	}
 
-	log.Printf(
-		"starting the stream on port %d "+
-			"(configure your firewall if necessary)",
-		*port,
-	)
 	streamHandler := stream{
-		sink:         sink,
 		mime:         *mime,
 		format:       *format,
 		bitrate:      *bitrate,
@@ -227,29 +340,8 @@ func main() {
 		samplerate:   *rate,
 		channels:     *channels,
 		nochunked:    *nochunked,
-	}
-
-	switch {
-	case *useaac:
-		streamHandler.format = "adts"
-		streamHandler.mime = "audio/aac"
-	case *useflac:
-		streamHandler.format = "flac"
-		streamHandler.mime = "audio/flac"
-		streamHandler.bitrate = 0
-	case *uselpcm:
-		streamHandler.format = "lpcm"
-		streamHandler.mime = fmt.Sprintf("audio/L%d;rate=%d;channels=%d", *bits, *rate, *channels)
-		streamHandler.bitrate = 0
-		streamHandler.be = true
-	case *uselpcmle:
-		streamHandler.format = "lpcm"
-		streamHandler.mime = fmt.Sprintf("audio/L%d;rate=%d;channels=%d", *bits, *rate, *channels)
-		streamHandler.bitrate = 0
-	case *usewav:
-		streamHandler.format = "wav"
-		streamHandler.mime = "audio/wav"
-		streamHandler.bitrate = 0
+		pipe:         &pipeline{sink: sink},
+		meta:         sess.meta,
 	}
 
 	streamHandler.contentfeat = dlnaContentFeatures{
@@ -262,81 +354,277 @@ func main() {
 			DLNA_ORG_FLAG_BACKGROUND_TRANSFERT_MODE,
 	}
 
-	streamPath := "stream." + strings.ToLower(streamHandler.format)
+	// the format presets start from the flag defaults
+	base := streamHandler
+
+	var preset string
+	switch {
+	case *useaac:
+		preset = "aac"
+	case *useflac:
+		preset = "flac"
+	case *uselpcm:
+		preset = "lpcm"
+	case *uselpcmle:
+		preset = "lpcmle"
+	case *usewav:
+		preset = "wav"
+	}
+	if preset != "" {
+		f, _ := findFormat(preset)
+		streamHandler.useFormat(f)
+	}
+
+	streams := []stream{streamHandler}
+
+	if *formats != "" {
+		streams, err = formatChain(base, strings.Split(*formats, ","))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "formats:", err)
+			cleanup()
+			os.Exit(1)
+		}
+	}
+
+	if *auto && len(DLNADevices) == 0 && *daemonMode {
+		log.Printf("auto: no renderer found yet, using %s", streams[0].format)
+	}
+	if *auto && len(DLNADevices) > 0 {
+		var sinks [][]string
+		for _, dev := range DLNADevices {
+			protocols, err := CMSinkProtocols(dev)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "auto: %s: %v\n", deviceName(dev), err)
+				cleanup()
+				os.Exit(1)
+			}
+			sinks = append(sinks, protocols)
+		}
+		// every supported format in the order of quality, the
+		// later ones are used if the renderer fails to play the first
+		streams = streams[:0]
+		want := pcmSpec{base.bitdepth, base.samplerate, base.channels}
+		for _, match := range autoFormats(sinks, want) {
+			st := base
+			st.pipe = &pipeline{sink: sink}
+			st.useFormat(match.format)
+			st.useProtocolInfo(match.info)
+			streams = append(streams, st)
+		}
+		if len(streams) == 0 {
+			fmt.Fprintln(os.Stderr, "auto: no supported format found")
+			cleanup()
+			os.Exit(1)
+		}
+		log.Printf("auto: using %s (%s)", streams[0].format, streams[0].mime)
+	}
+
+	// lpcm and wav are wrapped in-process, the rest needs ffmpeg
+	for _, st := range streams {
+		if st.raw() {
+			continue
+		}
+		if _, err := exec.LookPath("ffmpeg"); err != nil {
+			fmt.Fprintln(os.Stderr, "dependency:", err)
+			cleanup()
+			os.Exit(1)
+		}
+		break
+	}
+
+	// only the renderers may listen, and only if they know the token
+	access, err := newAccessList(*allow)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "allow:", err)
+		cleanup()
+		os.Exit(1)
+	}
+	// there are no renderers to restrict to
+	access.open = *dummy && *allow == ""
+	token, err := newToken()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "token:", err)
+		cleanup()
+		os.Exit(1)
+	}
 
 	mux := http.NewServeMux()
-	mux.Handle("/"+streamPath, streamHandler)
+	for i := range streams {
+		streams[i].path = token + "/stream." + strings.ToLower(streams[i].format)
+		mux.Handle("/"+streams[i].path, streams[i])
+	}
 	var logoHandler logo = logobytes
 	mux.Handle("/"+LOGO_PATH, logoHandler)
+	mux.HandleFunc("/"+token+"/events/", sess.handleEvent)
 	httpServer := &http.Server{
-		Addr:         fmt.Sprintf(":%d", *port),
 		ReadTimeout:  -1,
 		WriteTimeout: -1,
-		Handler:      mux,
+		Handler:      access.handler(mux),
 	}
-	go func() {
-		err := httpServer.ListenAndServe()
+	// plain http unless asked, not every renderer can do tls
+	scheme := "http"
+	if *useTLS || *tlsCert != "" || *tlsKey != "" {
+		httpServer.TLSConfig, err = tlsConfig(*tlsCert, *tlsKey, streamHost)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "server:", err)
+			fmt.Fprintln(os.Stderr, "tls:", err)
 			cleanup()
 			os.Exit(1)
 		}
-	}()
-	// detect when the stream server is up
-	for {
-		_, err := net.Dial("tcp", fmt.Sprintf(":%d", *port))
+		scheme = "https"
+	}
+	// listen on the stream ip only, with the zone of a link-local address
+	streamAddr := streamHost.String()
+	if streamHost.To4() == nil && streamHost.IsLinkLocalUnicast() {
+		ifname, err := findInterface(streamHost)
 		if err == nil {
-			break
+			streamAddr += "%" + ifname
 		}
 	}
+	var listener net.Listener
+	if _, _, splitErr := net.SplitHostPort(*listen); splitErr == nil {
+		listener, err = net.Listen("tcp", *listen)
+	} else {
+		// -port applies to the override without a port too
+		host := streamAddr
+		if *listen != "" {
+			host = strings.Trim(*listen, "[]")
+		}
+		listener, err = listenPorts(host, *port)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "server:", err)
+		if errors.Is(err, syscall.EADDRINUSE) {
+			fmt.Fprintln(os.Stderr, "server: try -port 0 or a range like 9000-9010")
+		}
+		cleanup()
+		os.Exit(1)
+	}
+	go func() {
+		var err error
+		if httpServer.TLSConfig != nil {
+			err = httpServer.ServeTLS(listener, "", "")
+		} else {
+			err = httpServer.Serve(listener)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "server:", err)
+			cleanup()
+			os.Exit(1)
+		}
+	}()
 
-	var (
-		streamURI string
-		logoURI   string
-		protocol  = "http"
+	boundPort := listener.Addr().(*net.TCPAddr).Port
+	log.Printf(
+		"starting the stream on port %d "+
+			"(configure your firewall if necessary)",
+		boundPort,
 	)
+	baseURI := fmt.Sprintf("%s://%s", scheme,
+		net.JoinHostPort(streamAddr, fmt.Sprint(boundPort)))
 
-	if !*dummy && *format == "mp3" && detectSonos(DLNADevice) {
-		protocol = "x-rincon-mp3radio"
+	for _, st := range streams {
+		log.Printf("stream URI: %s/%s\n", baseURI, st.path)
 	}
 
-	if streamHost.To4() != nil {
-		streamURI = fmt.Sprintf("%s://%s:%d/%s",
-			protocol, streamHost, *port, streamPath)
-		logoURI = fmt.Sprintf("http://%s:%d/%s",
-			streamHost, *port, LOGO_PATH)
-	} else {
-		var zone string
-		if streamHost.IsLinkLocalUnicast() {
-			ifname, err := findInterface(streamHost)
-			if err == nil {
-				zone = "%" + ifname
-			}
+	sess.mu.Lock()
+	sess.devices = DLNADevices
+	sess.access = access
+	// renderers send their events over plain http
+	if scheme == "http" {
+		sess.eventsURI = baseURI + "/" + token + "/events/"
+	}
+	if *exitOnStop {
+		sess.onStop = func() {
+			cleanup()
+			fmt.Println("the renderers stopped playing the stream, exiting...")
+			os.Exit(EXIT_STOPPED)
 		}
-		streamURI = fmt.Sprintf("%s://[%s%s]:%d/%s",
-			protocol, streamHost, zone, *port, streamPath)
-		logoURI = fmt.Sprintf("http://[%s%s]:%d/%s",
-			streamHost, zone, *port, LOGO_PATH)
+	}
+	sess.streams = streams
+	sess.baseURI = baseURI
+	sess.logoURI = baseURI + "/" + LOGO_PATH
+	sess.timeout = *timeout
+	sess.metadataReset = *metaReset
+	sess.daemon = netWatch
+	sess.mu.Unlock()
+	if *title != "" {
+		sess.meta.pin(nowPlaying{Title: *title})
 	}
 
-	log.Printf("stream URI: %s\n", streamURI)
+	readMetadata, err := chooseMetadataReader(*metaFrom, sink)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "metadata:", err)
+		cleanup()
+		os.Exit(1)
+	}
+	if readMetadata != nil {
+		go watchMetadata(readMetadata, sess.meta, sess.updateMetadata)
+	}
 
-	log.Println("setting avtransport URI and playing")
-	if !*dummy {
-		av := avsetup{
-			device:    DLNADevice,
-			stream:    streamHandler,
-			logoURI:   logoURI,
-			streamURI: streamURI,
-		}
-		err = AVSetAndPlay(av)
+	err = sess.play()
+	if err != nil && netWatch != nil {
+		// they may play once they come back
+		log.Println("transport:", err)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, "transport:", err)
+		cleanup()
+		os.Exit(1)
+	}
+	if netWatch != nil {
+		err = netWatch.listen(sess)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "transport:", err)
+			fmt.Fprintln(os.Stderr, "daemon:", err)
 			cleanup()
 			os.Exit(1)
 		}
 	}
 
-	isPlaying = true
+	if *control != "" {
+		err = serveControl(*control, sess, shutdown)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "control:", err)
+		}
+	}
+
+	// audio comes through stdin, no hotkeys then
+	capt, _ := parseCapture(sink)
+	_, fromStdin := capt.(stdinCapture)
+	if !*dummy && !fromStdin {
+		restoreTerm = hotkeys(sess)
+	}
 	select {}
 }
+
+func debugDevice(DLNADevice *goupnp.MaybeRootDevice) {
+	spew.Fdump(os.Stderr, DLNADevice)
+	var location string
+	urn := detectAVtransport(DLNADevice)
+	switch {
+	case urn == av1.URN_AVTransport_1:
+		clients, err := av1.NewAVTransport1ClientsByURL(DLNADevice.Location)
+		if err == nil {
+			location = clients[0].Location.String()
+		}
+		spew.Fdump(os.Stderr, clients, err)
+
+	case urn == av1.URN_AVTransport_2:
+		clients, err := av1.NewAVTransport2ClientsByURL(DLNADevice.Location)
+		if err == nil {
+			location = clients[0].Location.String()
+		}
+		spew.Fdump(os.Stderr, clients, err)
+	}
+
+	if location == "" {
+		return
+	}
+	resp, err := http.Get(location)
+	if err != nil {
+		return
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+	spew.Fprintln(os.Stderr, string(data))
+}
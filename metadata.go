@@ -0,0 +1,265 @@
+// MIT+NoAI License
+//
+// Copyright (c) 2023 ugjka <ugjka@proton.me>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights///
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// This code may not be used to train artificial intelligence computer models
+// or retrieved by artificial intelligence software or hardware.
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	METADATA_INTERVAL = 3 * time.Second
+	MPRIS_PREFIX      = "org.mpris.MediaPlayer2."
+	MPRIS_PATH        = "/org/mpris/MediaPlayer2"
+	MPRIS_PLAYER      = "org.mpris.MediaPlayer2.Player"
+	// shown when nothing is known about the audio
+	DEFAULT_TITLE  = "Audio Cast"
+	DEFAULT_ARTIST = "Blast"
+)
+
+// nowPlaying is the title and artist of what the source is playing
+type nowPlaying struct {
+	Title  string `json:"title"`
+	Artist string `json:"artist,omitempty"`
+}
+
+// streamTitle formats it like internet radios do
+func (np nowPlaying) streamTitle() string {
+	if np.Artist == "" {
+		return np.Title
+	}
+	return np.Artist + " - " + np.Title
+}
+
+// didl returns the title and artist for the renderers
+func (np nowPlaying) didl() (title, artist string) {
+	if np.Title == "" {
+		return DEFAULT_TITLE, DEFAULT_ARTIST
+	}
+	if np.Artist == "" {
+		return np.Title, DEFAULT_ARTIST
+	}
+	return np.Title, np.Artist
+}
+
+// metadata holds the latest now playing info,
+// it is shared by the streams and the session
+type metadata struct {
+	mu sync.Mutex
+	np nowPlaying
+	// the title was set by hand, the source doesn't change it
+	pinned bool
+}
+
+func (m *metadata) get() nowPlaying {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.np
+}
+
+// set reports whether the info changed
+func (m *metadata) set(np nowPlaying) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.pinned || m.np == np {
+		return false
+	}
+	m.np = np
+	return true
+}
+
+// pin sets a fixed title, an empty one unpins it
+func (m *metadata) pin(np nowPlaying) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.np = np
+	m.pinned = np.Title != ""
+}
+
+// metadataReader returns what's playing right now
+type metadataReader func() (nowPlaying, error)
+
+// chooseMetadataReader picks where the now playing info comes from:
+// mpris, pulse, auto (mpris, then pulse if no player has a title) or off
+func chooseMetadataReader(from string, source string) (metadataReader, error) {
+	capt, _ := parseCapture(source)
+	pc, fromPulse := capt.(pulseCapture)
+	fromSinkInputs := func() (nowPlaying, error) {
+		return readSinkInputs(pc.source)
+	}
+	switch from {
+	case "off":
+		return nil, nil
+	case "mpris":
+		mpris := &mprisReader{}
+		return mpris.read, nil
+	case "pulse":
+		if !fromPulse {
+			return nil, fmt.Errorf("%s: not a pulseaudio source", source)
+		}
+		return fromSinkInputs, nil
+	case "auto":
+		mpris := &mprisReader{}
+		return func() (nowPlaying, error) {
+			np, err := mpris.read()
+			if (err != nil || np.Title == "") && fromPulse {
+				return fromSinkInputs()
+			}
+			return np, err
+		}, nil
+	}
+	return nil, fmt.Errorf("%s: unknown metadata source", from)
+}
+
+// watchMetadata polls the reader and calls changed with the new info
+func watchMetadata(read metadataReader, meta *metadata, changed func(nowPlaying)) {
+	var lastErr string
+	for {
+		np, err := read()
+		if err != nil {
+			// don't repeat the same error every few seconds
+			if err.Error() != lastErr {
+				log.Println("metadata:", err)
+				lastErr = err.Error()
+			}
+		} else {
+			lastErr = ""
+			if meta.set(np) {
+				log.Printf("now playing: %s", np.streamTitle())
+				changed(np)
+			}
+		}
+		time.Sleep(METADATA_INTERVAL)
+	}
+}
+
+// mprisReader reads the metadata of media players on the session bus
+type mprisReader struct {
+	conn *dbus.Conn
+}
+
+func (m *mprisReader) connect() (*dbus.Conn, error) {
+	if m.conn != nil && m.conn.Connected() {
+		return m.conn, nil
+	}
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return nil, fmt.Errorf("mpris: %v", err)
+	}
+	m.conn = conn
+	return conn, nil
+}
+
+// read returns the playing player's metadata, or a paused one's
+// if none is playing
+func (m *mprisReader) read() (nowPlaying, error) {
+	conn, err := m.connect()
+	if err != nil {
+		return nowPlaying{}, err
+	}
+	var names []string
+	err = conn.BusObject().Call("org.freedesktop.DBus.ListNames", 0).Store(&names)
+	if err != nil {
+		return nowPlaying{}, fmt.Errorf("mpris: %v", err)
+	}
+	var paused *nowPlaying
+	for _, name := range names {
+		if !strings.HasPrefix(name, MPRIS_PREFIX) {
+			continue
+		}
+		player := conn.Object(name, MPRIS_PATH)
+		status, err := player.GetProperty(MPRIS_PLAYER + ".PlaybackStatus")
+		if err != nil {
+			continue
+		}
+		meta, err := player.GetProperty(MPRIS_PLAYER + ".Metadata")
+		if err != nil {
+			continue
+		}
+		fields, _ := meta.Value().(map[string]dbus.Variant)
+		np := mprisNowPlaying(fields)
+		switch status.Value() {
+		case "Playing":
+			return np, nil
+		case "Paused":
+			if paused == nil {
+				paused = &np
+			}
+		}
+	}
+	if paused != nil {
+		return *paused, nil
+	}
+	return nowPlaying{}, nil
+}
+
+// mprisNowPlaying picks the title and artists from mpris metadata
+func mprisNowPlaying(fields map[string]dbus.Variant) nowPlaying {
+	var np nowPlaying
+	if title, ok := fields["xesam:title"].Value().(string); ok {
+		np.Title = title
+	}
+	if artists, ok := fields["xesam:artist"].Value().([]string); ok {
+		np.Artist = strings.Join(artists, ", ")
+	}
+	return np
+}
+
+// readSinkInputs takes the title from the properties of the newest
+// pulseaudio stream that has one and plays to the sink the source
+// monitors, e.g. the app feeding blast.monitor
+func readSinkInputs(source string) (nowPlaying, error) {
+	sink, err := pulse.monitorSink(source)
+	if err != nil {
+		return nowPlaying{}, err
+	}
+	inputs, err := pulse.sinkInputs()
+	if err != nil {
+		return nowPlaying{}, err
+	}
+	var np nowPlaying
+	for _, input := range inputs {
+		if input.Sink != sink {
+			continue
+		}
+		title := input.Properties["media.title"]
+		if title == "" {
+			title = input.Properties["media.name"]
+		}
+		if title == "" {
+			continue
+		}
+		np = nowPlaying{
+			Title:  title,
+			Artist: input.Properties["media.artist"],
+		}
+	}
+	return np, nil
+}
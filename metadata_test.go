@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+)
+
+type fakePlayer struct {
+	status string
+	meta   map[string]dbus.Variant
+}
+
+func (p fakePlayer) Get(iface, prop string) (dbus.Variant, *dbus.Error) {
+	switch prop {
+	case "PlaybackStatus":
+		return dbus.MakeVariant(p.status), nil
+	case "Metadata":
+		return dbus.MakeVariant(p.meta), nil
+	}
+	return dbus.Variant{}, dbus.MakeFailedError(nil)
+}
+
+// startBus runs a private session bus for the test
+func startBus(t *testing.T) {
+	if _, err := exec.LookPath("dbus-daemon"); err != nil {
+		t.Skip("no dbus-daemon")
+	}
+	daemon := exec.Command("dbus-daemon", "--session", "--nofork", "--print-address=1")
+	stdout, err := daemon.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := daemon.Start(); err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() {
+		daemon.Process.Kill()
+		daemon.Wait()
+	})
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", strings.TrimSpace(address))
+}
+
+func TestMPRIS(t *testing.T) {
+	startBus(t)
+	players := map[string]fakePlayer{
+		"paused": {"Paused", map[string]dbus.Variant{
+			"xesam:title": dbus.MakeVariant("Paused Song"),
+		}},
+		"playing": {"Playing", map[string]dbus.Variant{
+			"xesam:title":  dbus.MakeVariant("Song"),
+			"xesam:artist": dbus.MakeVariant([]string{"Band", "Guest"}),
+		}},
+	}
+	for name, player := range players {
+		// every player needs its own bus name
+		c, err := dbus.ConnectSessionBus()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		c.Export(player, MPRIS_PATH, "org.freedesktop.DBus.Properties")
+		if _, err := c.RequestName(MPRIS_PREFIX+name, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	np, err := (&mprisReader{}).read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nowPlaying{Title: "Song", Artist: "Band, Guest"}
+	if np != want {
+		t.Fatalf("got %+v, wanted %+v", np, want)
+	}
+}
+
+func TestIcyWriter(t *testing.T) {
+	meta := &metadata{}
+	meta.set(nowPlaying{Title: "Song", Artist: "Band"})
+	var out bytes.Buffer
+	iw := newIcyWriter(&out, meta)
+	audio := bytes.Repeat([]byte{0xff}, ICY_METAINT*2)
+	// odd sized writes cross the block boundaries
+	for len(audio) > 0 {
+		n := 7777
+		if n > len(audio) {
+			n = len(audio)
+		}
+		iw.Write(audio[:n])
+		audio = audio[n:]
+	}
+	got := out.Bytes()
+	block := got[ICY_METAINT:]
+	if int(block[0])*16 != 32 || !bytes.HasPrefix(block[1:], []byte("StreamTitle='Band - Song';")) {
+		t.Fatalf("bad first block: %q", block[:33])
+	}
+	// the title didn't change, so the second block is empty
+	second := got[ICY_METAINT+33+ICY_METAINT:]
+	if len(second) != 1 || second[0] != 0 {
+		t.Fatalf("second block should be empty, got %q", second)
+	}
+}
+
+// fakeSinks plays a browser to the speakers and a player to blast
+type fakeSinks struct {
+	pulseExec
+}
+
+func (fakeSinks) monitorSink(source string) (int, error) {
+	return map[string]int{"speakers.monitor": 0, "blast.monitor": 1}[source], nil
+}
+
+func (fakeSinks) sinkInputs() ([]sinkInput, error) {
+	return []sinkInput{
+		{Index: 1, Sink: 1, Properties: map[string]string{"media.title": "Song", "media.artist": "Band"}},
+		{Index: 2, Sink: 0, Properties: map[string]string{"media.name": "Video"}},
+	}, nil
+}
+
+func TestReadSinkInputs(t *testing.T) {
+	defer func(p pulseBackend) { pulse = p }(pulse)
+	pulse = fakeSinks{}
+	np, err := readSinkInputs("blast.monitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if np.Title != "Song" || np.Artist != "Band" {
+		t.Fatalf("got %+v, wanted the app playing to blast", np)
+	}
+}
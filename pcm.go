@@ -0,0 +1,94 @@
+// MIT+NoAI License
+//
+// Copyright (c) 2023 ugjka <ugjka@proton.me>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights///
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// This code may not be used to train artificial intelligence computer models
+// or retrieved by artificial intelligence software or hardware.
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+const (
+	WAVE_FORMAT_PCM        = 0x0001
+	WAVE_FORMAT_EXTENSIBLE = 0xfffe
+	// sizes for a stream that never ends
+	WAV_ENDLESS = 0xffffffff
+)
+
+// pcm subformat guid of WAVE_FORMAT_EXTENSIBLE
+var KSDATAFORMAT_SUBTYPE_PCM = []byte{
+	0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10, 0x00,
+	0x80, 0x00, 0x00, 0xaa, 0x00, 0x38, 0x9b, 0x71,
+}
+
+// wavHeader builds the header of an endless little-endian wav stream,
+// the extensible format is used for more than 16 bits or 2 channels
+func wavHeader(bitdepth, samplerate, channels int) []byte {
+	blockAlign := bitdepth / 8 * channels
+	extensible := bitdepth > 16 || channels > 2
+
+	var fmtChunk bytes.Buffer
+	put := func(w *bytes.Buffer, v any) {
+		binary.Write(w, binary.LittleEndian, v)
+	}
+	tag := uint16(WAVE_FORMAT_PCM)
+	if extensible {
+		tag = WAVE_FORMAT_EXTENSIBLE
+	}
+	put(&fmtChunk, tag)
+	put(&fmtChunk, uint16(channels))
+	put(&fmtChunk, uint32(samplerate))
+	put(&fmtChunk, uint32(samplerate*blockAlign))
+	put(&fmtChunk, uint16(blockAlign))
+	put(&fmtChunk, uint16(bitdepth))
+	if extensible {
+		put(&fmtChunk, uint16(22))
+		// valid bits per sample
+		put(&fmtChunk, uint16(bitdepth))
+		put(&fmtChunk, channelMask(channels))
+		fmtChunk.Write(KSDATAFORMAT_SUBTYPE_PCM)
+	}
+
+	var header bytes.Buffer
+	header.WriteString("RIFF")
+	put(&header, uint32(WAV_ENDLESS))
+	header.WriteString("WAVEfmt ")
+	put(&header, uint32(fmtChunk.Len()))
+	header.Write(fmtChunk.Bytes())
+	header.WriteString("data")
+	put(&header, uint32(WAV_ENDLESS))
+	return header.Bytes()
+}
+
+// channelMask returns the default speaker positions
+func channelMask(channels int) uint32 {
+	masks := map[int]uint32{
+		1: 0x4,   // center
+		2: 0x3,   // left, right
+		4: 0x33,  // quad
+		6: 0x3f,  // 5.1
+		8: 0x63f, // 7.1
+	}
+	return masks[channels]
+}
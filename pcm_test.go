@@ -0,0 +1,24 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestWavHeader(t *testing.T) {
+	for _, bits := range []int{16, 24} {
+		header := wavHeader(bits, 48000, 2)
+		stream := append(header, 1, 2, 3, 4)
+		parsed, err := readStreamHeader("wav", bufio.NewReader(bytes.NewReader(stream)))
+		if err != nil {
+			t.Fatalf("%d bits: %v", bits, err)
+		}
+		if !bytes.Equal(parsed, header) {
+			t.Fatalf("%d bits: header is %d bytes, parsed %d", bits, len(header), len(parsed))
+		}
+	}
+	if len(wavHeader(16, 44100, 2)) != 44 {
+		t.Fatal("16 bit stereo should have the canonical 44 byte header")
+	}
+}
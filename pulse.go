@@ -0,0 +1,184 @@
+// MIT+NoAI License
+//
+// Copyright (c) 2023 ugjka <ugjka@proton.me>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights///
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// This code may not be used to train artificial intelligence computer models
+// or retrieved by artificial intelligence software or hardware.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// pulseBackend talks to the pulseaudio or pipewire-pulse server
+type pulseBackend interface {
+	listSources() (Sources, error)
+	// loadModule returns the module's id
+	loadModule(name, args string) (string, error)
+	unloadModule(id string) error
+	// record captures raw pcm from the source
+	record(source string, spec sampleSpec) (io.ReadCloser, error)
+	// sinkInputs lists the streams playing to the sinks
+	sinkInputs() ([]sinkInput, error)
+	// monitorSink returns the index of the sink the source monitors
+	monitorSink(source string) (int, error)
+}
+
+// the backend in use, set up in main
+var pulse pulseBackend = pulseExec{}
+
+type sampleSpec struct {
+	// parec style format, e.g. s16le
+	format   string
+	rate     int
+	channels int
+}
+
+type sinkInput struct {
+	Index int `json:"index"`
+	// the index of the sink it plays to
+	Sink       int               `json:"sink"`
+	Properties map[string]string `json:"properties"`
+}
+
+// choosePulseBackend returns the backend by its name, auto
+// tries the native protocol and falls back to pactl and parec
+func choosePulseBackend(name string) (pulseBackend, error) {
+	switch name {
+	case "exec":
+		return pulseExec{}, nil
+	case "native":
+		native := pulseNative{}
+		if err := native.ping(); err != nil {
+			return nil, err
+		}
+		return native, nil
+	case "auto":
+		native := pulseNative{}
+		if err := native.ping(); err == nil {
+			return native, nil
+		}
+		return pulseExec{}, nil
+	}
+	return nil, fmt.Errorf("%s: unknown backend", name)
+}
+
+// pulseExec shells out to pactl and parec
+type pulseExec struct{}
+
+func (pulseExec) listSources() (Sources, error) {
+	srcCMD := exec.Command("pactl", "-f", "json", "list", "sources", "short")
+	srcData, err := srcCMD.Output()
+	if err != nil {
+		return nil, fmt.Errorf("pactl sources: %v", err)
+	}
+
+	var srcJSON Sources
+	err = json.Unmarshal(srcData, &srcJSON)
+	if err != nil {
+		return nil, err
+	}
+	return srcJSON, nil
+}
+
+func (pulseExec) sinkInputs() ([]sinkInput, error) {
+	data, err := exec.Command("pactl", "-f", "json", "list", "sink-inputs").Output()
+	if err != nil {
+		return nil, fmt.Errorf("pactl sink-inputs: %v", err)
+	}
+	var inputs []sinkInput
+	err = json.Unmarshal(data, &inputs)
+	if err != nil {
+		return nil, fmt.Errorf("pactl sink-inputs: %v", err)
+	}
+	return inputs, nil
+}
+
+func (pulseExec) monitorSink(source string) (int, error) {
+	data, err := exec.Command("pactl", "-f", "json", "list", "sinks").Output()
+	if err != nil {
+		return 0, fmt.Errorf("pactl sinks: %v", err)
+	}
+	var sinks []struct {
+		Index         int    `json:"index"`
+		MonitorSource string `json:"monitor_source"`
+	}
+	err = json.Unmarshal(data, &sinks)
+	if err != nil {
+		return 0, fmt.Errorf("pactl sinks: %v", err)
+	}
+	for _, sink := range sinks {
+		if sink.MonitorSource == source {
+			return sink.Index, nil
+		}
+	}
+	return 0, fmt.Errorf("%s: not a sink monitor", source)
+}
+
+func (pulseExec) loadModule(name, args string) (string, error) {
+	id, err := exec.Command("pactl", "load-module", name, args).Output()
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimSpace(id)), nil
+}
+
+func (pulseExec) unloadModule(id string) error {
+	return exec.Command("pactl", "unload-module", id).Run()
+}
+
+func (pulseExec) record(source string, spec sampleSpec) (io.ReadCloser, error) {
+	parecCMD := exec.Command(
+		"parec",
+		"--device="+source,
+		"--client-name=blast-rec",
+		"--rate="+fmt.Sprint(spec.rate),
+		"--channels="+fmt.Sprint(spec.channels),
+		"--format="+spec.format,
+		"--raw",
+	)
+	return startCommand(parecCMD)
+}
+
+// execReader kills the process when closed
+type execReader struct {
+	io.ReadCloser
+	cmd  *exec.Cmd
+	once sync.Once
+}
+
+func (r *execReader) Close() error {
+	r.once.Do(func() {
+		r.cmd.Process.Kill()
+		err := r.cmd.Wait()
+		if err != nil && !strings.Contains(err.Error(), "signal") {
+			log.Printf("%s: %v", r.cmd.Args[0], err)
+		}
+	})
+	return nil
+}
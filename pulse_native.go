@@ -0,0 +1,711 @@
+// MIT+NoAI License
+//
+// Copyright (c) 2023 ugjka <ugjka@proton.me>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights///
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// This code may not be used to train artificial intelligence computer models
+// or retrieved by artificial intelligence software or hardware.
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// a minimal client for the pulseaudio native protocol, just enough
+// to list sources, load modules and record. pipewire-pulse speaks it too.
+
+const (
+	PA_PROTOCOL_VERSION = 13
+	PA_INVALID_INDEX    = 0xFFFFFFFF
+	PA_COMMAND_CHANNEL  = 0xFFFFFFFF
+	PA_DESCRIPTOR_SIZE  = 20
+	PA_COOKIE_SIZE      = 256
+	PA_MAX_FRAME_SIZE   = 16 * 1024 * 1024
+)
+
+const (
+	PA_COMMAND_ERROR                    = 0
+	PA_COMMAND_REPLY                    = 2
+	PA_COMMAND_CREATE_RECORD_STREAM     = 5
+	PA_COMMAND_AUTH                     = 8
+	PA_COMMAND_SET_CLIENT_NAME          = 9
+	PA_COMMAND_GET_SOURCE_INFO_LIST     = 24
+	PA_COMMAND_GET_SINK_INPUT_INFO_LIST = 30
+	PA_COMMAND_LOAD_MODULE              = 51
+	PA_COMMAND_UNLOAD_MODULE            = 52
+	PA_COMMAND_RECORD_STREAM_KILLED     = 65
+)
+
+// tagstruct tags
+const (
+	PA_TAG_STRING      = 't'
+	PA_TAG_STRING_NULL = 'N'
+	PA_TAG_U32         = 'L'
+	PA_TAG_U8          = 'B'
+	PA_TAG_U64         = 'R'
+	PA_TAG_S64         = 'r'
+	PA_TAG_SAMPLE_SPEC = 'a'
+	PA_TAG_ARBITRARY   = 'x'
+	PA_TAG_TRUE        = '1'
+	PA_TAG_FALSE       = '0'
+	PA_TAG_TIMEVAL     = 'T'
+	PA_TAG_USEC        = 'U'
+	PA_TAG_CHANNEL_MAP = 'm'
+	PA_TAG_CVOLUME     = 'v'
+	PA_TAG_PROPLIST    = 'P'
+	PA_TAG_VOLUME      = 'V'
+	PA_TAG_FORMAT_INFO = 'f'
+)
+
+var pulseSampleFormats = map[string]byte{
+	"u8":    0,
+	"s16le": 3,
+	"s16be": 4,
+	"s32le": 7,
+	"s32be": 8,
+	"s24le": 9,
+	"s24be": 10,
+}
+
+type tagWriter struct {
+	buf []byte
+}
+
+func (w *tagWriter) u32(v uint32) {
+	w.buf = append(w.buf, PA_TAG_U32)
+	w.buf = binary.BigEndian.AppendUint32(w.buf, v)
+}
+
+func (w *tagWriter) u8(v byte) {
+	w.buf = append(w.buf, PA_TAG_U8, v)
+}
+
+func (w *tagWriter) boolean(v bool) {
+	if v {
+		w.buf = append(w.buf, PA_TAG_TRUE)
+	} else {
+		w.buf = append(w.buf, PA_TAG_FALSE)
+	}
+}
+
+func (w *tagWriter) str(s string) {
+	w.buf = append(w.buf, PA_TAG_STRING)
+	w.buf = append(w.buf, s...)
+	w.buf = append(w.buf, 0)
+}
+
+func (w *tagWriter) null() {
+	w.buf = append(w.buf, PA_TAG_STRING_NULL)
+}
+
+func (w *tagWriter) arbitrary(data []byte) {
+	w.buf = append(w.buf, PA_TAG_ARBITRARY)
+	w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(len(data)))
+	w.buf = append(w.buf, data...)
+}
+
+func (w *tagWriter) sampleSpec(format byte, channels byte, rate uint32) {
+	w.buf = append(w.buf, PA_TAG_SAMPLE_SPEC, format, channels)
+	w.buf = binary.BigEndian.AppendUint32(w.buf, rate)
+}
+
+func (w *tagWriter) channelMap(positions []byte) {
+	w.buf = append(w.buf, PA_TAG_CHANNEL_MAP, byte(len(positions)))
+	w.buf = append(w.buf, positions...)
+}
+
+func (w *tagWriter) proplist(props map[string]string) {
+	w.buf = append(w.buf, PA_TAG_PROPLIST)
+	for key, value := range props {
+		data := append([]byte(value), 0)
+		w.str(key)
+		w.u32(uint32(len(data)))
+		w.arbitrary(data)
+	}
+	w.null()
+}
+
+type tagReader struct {
+	buf []byte
+	err error
+}
+
+func (r *tagReader) fail(format string, args ...any) {
+	if r.err == nil {
+		r.err = fmt.Errorf("tagstruct: "+format, args...)
+	}
+}
+
+func (r *tagReader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.buf) < n {
+		r.fail("short read")
+		return nil
+	}
+	data := r.buf[:n]
+	r.buf = r.buf[n:]
+	return data
+}
+
+func (r *tagReader) tag(want byte) bool {
+	t := r.take(1)
+	if t == nil {
+		return false
+	}
+	if t[0] != want {
+		r.fail("got tag %q, wanted %q", t[0], want)
+		return false
+	}
+	return true
+}
+
+func (r *tagReader) u32() uint32 {
+	if !r.tag(PA_TAG_U32) {
+		return 0
+	}
+	data := r.take(4)
+	if data == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(data)
+}
+
+func (r *tagReader) u64(tag byte) uint64 {
+	if !r.tag(tag) {
+		return 0
+	}
+	data := r.take(8)
+	if data == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(data)
+}
+
+func (r *tagReader) boolean() bool {
+	t := r.take(1)
+	if t == nil {
+		return false
+	}
+	switch t[0] {
+	case PA_TAG_TRUE:
+		return true
+	case PA_TAG_FALSE:
+		return false
+	}
+	r.fail("got tag %q, wanted a boolean", t[0])
+	return false
+}
+
+func (r *tagReader) str() string {
+	t := r.take(1)
+	if t == nil {
+		return ""
+	}
+	switch t[0] {
+	case PA_TAG_STRING_NULL:
+		return ""
+	case PA_TAG_STRING:
+	default:
+		r.fail("got tag %q, wanted a string", t[0])
+		return ""
+	}
+	end := -1
+	for i, b := range r.buf {
+		if b == 0 {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		r.fail("unterminated string")
+		return ""
+	}
+	s := string(r.buf[:end])
+	r.buf = r.buf[end+1:]
+	return s
+}
+
+func (r *tagReader) sampleSpec() (format byte, channels byte, rate uint32) {
+	if !r.tag(PA_TAG_SAMPLE_SPEC) {
+		return
+	}
+	data := r.take(6)
+	if data == nil {
+		return
+	}
+	return data[0], data[1], binary.BigEndian.Uint32(data[2:])
+}
+
+func (r *tagReader) channelMap() {
+	if !r.tag(PA_TAG_CHANNEL_MAP) {
+		return
+	}
+	n := r.take(1)
+	if n != nil {
+		r.take(int(n[0]))
+	}
+}
+
+func (r *tagReader) cvolume() {
+	if !r.tag(PA_TAG_CVOLUME) {
+		return
+	}
+	n := r.take(1)
+	if n != nil {
+		r.take(int(n[0]) * 4)
+	}
+}
+
+func (r *tagReader) proplist() map[string]string {
+	if !r.tag(PA_TAG_PROPLIST) {
+		return nil
+	}
+	props := make(map[string]string)
+	for r.err == nil {
+		if len(r.buf) > 0 && r.buf[0] == PA_TAG_STRING_NULL {
+			r.take(1)
+			return props
+		}
+		key := r.str()
+		size := r.u32()
+		if !r.tag(PA_TAG_ARBITRARY) {
+			return nil
+		}
+		if n := r.take(4); n == nil || binary.BigEndian.Uint32(n) != size {
+			r.fail("bad proplist value")
+			return nil
+		}
+		value := r.take(int(size))
+		props[key] = strings.TrimRight(string(value), "\x00")
+	}
+	return nil
+}
+
+// pulseConn is a connection to the server, used for a single
+// request-reply exchange or a single record stream
+type pulseConn struct {
+	conn    net.Conn
+	tag     uint32
+	version uint32
+}
+
+func pulseServerAddress() (network, address string) {
+	if server := os.Getenv("PULSE_SERVER"); server != "" {
+		// the first entry of the list
+		server = strings.Fields(server)[0]
+		switch {
+		case strings.HasPrefix(server, "unix:"):
+			return "unix", strings.TrimPrefix(server, "unix:")
+		case strings.HasPrefix(server, "tcp:"):
+			address := strings.TrimPrefix(server, "tcp:")
+			if _, _, err := net.SplitHostPort(address); err != nil {
+				address = net.JoinHostPort(address, "4713")
+			}
+			return "tcp", address
+		case strings.HasPrefix(server, "/"):
+			return "unix", server
+		}
+	}
+	runtime := os.Getenv("XDG_RUNTIME_DIR")
+	if runtime == "" {
+		runtime = filepath.Join("/run/user", strconv.Itoa(os.Getuid()))
+	}
+	return "unix", filepath.Join(runtime, "pulse", "native")
+}
+
+func pulseCookie() []byte {
+	var paths []string
+	if cookie := os.Getenv("PULSE_COOKIE"); cookie != "" {
+		paths = append(paths, cookie)
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		paths = append(paths, filepath.Join(dir, "pulse", "cookie"))
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".pulse-cookie"))
+	}
+	for _, path := range paths {
+		cookie, err := os.ReadFile(path)
+		if err == nil && len(cookie) >= PA_COOKIE_SIZE {
+			return cookie[:PA_COOKIE_SIZE]
+		}
+	}
+	// pipewire-pulse doesn't check it
+	return make([]byte, PA_COOKIE_SIZE)
+}
+
+func dialPulse(clientName string) (*pulseConn, error) {
+	network, address := pulseServerAddress()
+	conn, err := net.DialTimeout(network, address, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	c := &pulseConn{conn: conn}
+
+	reply, err := c.request(PA_COMMAND_AUTH, func(w *tagWriter) {
+		w.u32(PA_PROTOCOL_VERSION)
+		w.arbitrary(pulseCookie())
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("auth: %v", err)
+	}
+	// the upper bits are shm and memfd flags
+	c.version = reply.u32() & 0xFFFF
+	if c.version > PA_PROTOCOL_VERSION {
+		c.version = PA_PROTOCOL_VERSION
+	}
+	if c.version < PA_PROTOCOL_VERSION {
+		conn.Close()
+		return nil, fmt.Errorf("protocol version %d is too old", c.version)
+	}
+
+	_, err = c.request(PA_COMMAND_SET_CLIENT_NAME, func(w *tagWriter) {
+		w.proplist(map[string]string{"application.name": clientName})
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("client name: %v", err)
+	}
+	return c, nil
+}
+
+func (c *pulseConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *pulseConn) writePacket(channel uint32, payload []byte) error {
+	packet := make([]byte, PA_DESCRIPTOR_SIZE, PA_DESCRIPTOR_SIZE+len(payload))
+	binary.BigEndian.PutUint32(packet[0:], uint32(len(payload)))
+	binary.BigEndian.PutUint32(packet[4:], channel)
+	packet = append(packet, payload...)
+	_, err := c.conn.Write(packet)
+	return err
+}
+
+func (c *pulseConn) readPacket() (channel uint32, payload []byte, err error) {
+	descriptor := make([]byte, PA_DESCRIPTOR_SIZE)
+	if _, err = io.ReadFull(c.conn, descriptor); err != nil {
+		return
+	}
+	size := binary.BigEndian.Uint32(descriptor[0:])
+	channel = binary.BigEndian.Uint32(descriptor[4:])
+	if size > PA_MAX_FRAME_SIZE {
+		err = fmt.Errorf("frame too large: %d", size)
+		return
+	}
+	payload = make([]byte, size)
+	_, err = io.ReadFull(c.conn, payload)
+	return
+}
+
+// request sends a command and waits for its reply
+func (c *pulseConn) request(command uint32, args func(w *tagWriter)) (*tagReader, error) {
+	c.tag++
+	tag := c.tag
+	w := &tagWriter{}
+	w.u32(command)
+	w.u32(tag)
+	if args != nil {
+		args(w)
+	}
+	if err := c.writePacket(PA_COMMAND_CHANNEL, w.buf); err != nil {
+		return nil, err
+	}
+	for {
+		channel, payload, err := c.readPacket()
+		if err != nil {
+			return nil, err
+		}
+		if channel != PA_COMMAND_CHANNEL {
+			continue
+		}
+		r := &tagReader{buf: payload}
+		reply := r.u32()
+		replyTag := r.u32()
+		if r.err != nil {
+			return nil, r.err
+		}
+		if replyTag != tag {
+			continue
+		}
+		switch reply {
+		case PA_COMMAND_REPLY:
+			return r, nil
+		case PA_COMMAND_ERROR:
+			return nil, fmt.Errorf("error code %d", r.u32())
+		}
+		return nil, fmt.Errorf("unexpected reply %d", reply)
+	}
+}
+
+// pulseNative speaks the native protocol over the user's socket
+type pulseNative struct{}
+
+func (pulseNative) ping() error {
+	c, err := dialPulse("blast")
+	if err != nil {
+		return err
+	}
+	return c.Close()
+}
+
+func (pulseNative) listSources() (Sources, error) {
+	c, err := dialPulse("blast")
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	r, err := c.request(PA_COMMAND_GET_SOURCE_INFO_LIST, nil)
+	if err != nil {
+		return nil, fmt.Errorf("sources: %v", err)
+	}
+	var sources Sources
+	for len(r.buf) > 0 && r.err == nil {
+		var source Source
+		source.Index = int(r.u32())
+		source.Name = r.str()
+		r.str() // description
+		format, channels, rate := r.sampleSpec()
+		r.channelMap()
+		r.u32() // owner module
+		r.cvolume()
+		r.boolean() // mute
+		source.monitorOf = r.u32()
+		r.str() // monitor of sink name
+		r.u64(PA_TAG_USEC)
+		source.Driver = r.str()
+		r.u32() // flags
+		r.proplist()
+		r.u64(PA_TAG_USEC) // configured latency
+		source.SampleSpec = fmt.Sprintf("%s %dch %dHz",
+			pulseFormatName(format), channels, rate)
+		sources = append(sources, source)
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("sources: %v", r.err)
+	}
+	return sources, nil
+}
+
+func (pulseNative) sinkInputs() ([]sinkInput, error) {
+	c, err := dialPulse("blast")
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	r, err := c.request(PA_COMMAND_GET_SINK_INPUT_INFO_LIST, nil)
+	if err != nil {
+		return nil, fmt.Errorf("sink inputs: %v", err)
+	}
+	var inputs []sinkInput
+	for len(r.buf) > 0 && r.err == nil {
+		var input sinkInput
+		input.Index = int(r.u32())
+		r.str() // name
+		r.u32() // owner module
+		r.u32() // client
+		input.Sink = int(r.u32())
+		r.sampleSpec()
+		r.channelMap()
+		r.cvolume()
+		r.u64(PA_TAG_USEC) // buffer latency
+		r.u64(PA_TAG_USEC) // sink latency
+		r.str()            // resample method
+		r.str()            // driver
+		r.boolean()        // mute
+		input.Properties = r.proplist()
+		inputs = append(inputs, input)
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("sink inputs: %v", r.err)
+	}
+	return inputs, nil
+}
+
+func pulseFormatName(format byte) string {
+	for name, f := range pulseSampleFormats {
+		if f == format {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+func (p pulseNative) monitorSink(source string) (int, error) {
+	sources, err := p.listSources()
+	if err != nil {
+		return 0, err
+	}
+	for _, s := range sources {
+		if s.Name == source && s.monitorOf != PA_INVALID_INDEX {
+			return int(s.monitorOf), nil
+		}
+	}
+	return 0, fmt.Errorf("%s: not a sink monitor", source)
+}
+
+func (pulseNative) loadModule(name, args string) (string, error) {
+	c, err := dialPulse("blast")
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+	r, err := c.request(PA_COMMAND_LOAD_MODULE, func(w *tagWriter) {
+		w.str(name)
+		w.str(args)
+	})
+	if err != nil {
+		return "", fmt.Errorf("load module: %v", err)
+	}
+	id := r.u32()
+	if r.err != nil {
+		return "", r.err
+	}
+	return fmt.Sprint(id), nil
+}
+
+func (pulseNative) unloadModule(id string) error {
+	index, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return err
+	}
+	c, err := dialPulse("blast")
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	_, err = c.request(PA_COMMAND_UNLOAD_MODULE, func(w *tagWriter) {
+		w.u32(uint32(index))
+	})
+	return err
+}
+
+func (pulseNative) record(source string, spec sampleSpec) (io.ReadCloser, error) {
+	format, ok := pulseSampleFormats[spec.format]
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported sample format", spec.format)
+	}
+	c, err := dialPulse("blast-rec")
+	if err != nil {
+		return nil, err
+	}
+	positions := make([]byte, spec.channels)
+	switch spec.channels {
+	case 1:
+		// mono
+	case 2:
+		positions = []byte{1, 2}
+	default:
+		for i := range positions {
+			// aux0 and up
+			positions[i] = byte(12 + i)
+		}
+	}
+	frame := spec.channels * sampleSize(spec.format)
+	// 50ms fragments keep the latency low
+	fragsize := spec.rate * frame / 20
+	r, err := c.request(PA_COMMAND_CREATE_RECORD_STREAM, func(w *tagWriter) {
+		w.sampleSpec(format, byte(spec.channels), uint32(spec.rate))
+		w.channelMap(positions)
+		w.u32(PA_INVALID_INDEX)
+		w.str(source)
+		w.u32(PA_INVALID_INDEX) // maxlength
+		w.boolean(false)        // corked
+		w.u32(uint32(fragsize))
+		w.boolean(false) // no remap
+		w.boolean(false) // no remix
+		w.boolean(false) // fix format
+		w.boolean(false) // fix rate
+		w.boolean(false) // fix channels
+		w.boolean(false) // don't move
+		w.boolean(false) // variable rate
+		w.boolean(false) // peak detect
+		w.boolean(true)  // adjust latency
+		w.proplist(map[string]string{"media.name": "blast"})
+		w.u32(PA_INVALID_INDEX) // direct on input
+	})
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("record stream: %v", err)
+	}
+	channel := r.u32()
+	if r.err != nil {
+		c.Close()
+		return nil, r.err
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		for {
+			ch, payload, err := c.readPacket()
+			if err != nil {
+				writer.CloseWithError(err)
+				return
+			}
+			if ch == channel {
+				if _, err := writer.Write(payload); err != nil {
+					c.Close()
+					return
+				}
+				continue
+			}
+			t := &tagReader{buf: payload}
+			if t.u32() == PA_COMMAND_RECORD_STREAM_KILLED {
+				writer.CloseWithError(fmt.Errorf("record stream killed"))
+				c.Close()
+				return
+			}
+		}
+	}()
+	return &nativeReader{PipeReader: reader, conn: c}, nil
+}
+
+type nativeReader struct {
+	*io.PipeReader
+	conn *pulseConn
+}
+
+func (r *nativeReader) Close() error {
+	r.conn.Close()
+	return r.PipeReader.Close()
+}
+
+func sampleSize(format string) int {
+	switch {
+	case strings.HasPrefix(format, "s32"):
+		return 4
+	case strings.HasPrefix(format, "s24"):
+		return 3
+	case strings.HasPrefix(format, "s16"):
+		return 2
+	}
+	return 1
+}
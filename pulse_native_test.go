@@ -0,0 +1,125 @@
+package main
+
+import (
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+// fakePulseServer answers just enough of the native protocol
+func fakePulseServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "native")
+	t.Setenv("PULSE_SERVER", "unix:"+path)
+	t.Setenv("PULSE_COOKIE", filepath.Join(t.TempDir(), "cookie"))
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				c := &pulseConn{conn: conn}
+				for {
+					_, payload, err := c.readPacket()
+					if err != nil {
+						return
+					}
+					r := &tagReader{buf: payload}
+					command, tag := r.u32(), r.u32()
+					w := &tagWriter{}
+					w.u32(PA_COMMAND_REPLY)
+					w.u32(tag)
+					switch command {
+					case PA_COMMAND_AUTH:
+						w.u32(35 | 0x80000000)
+					case PA_COMMAND_SET_CLIENT_NAME:
+						w.u32(1)
+					case PA_COMMAND_GET_SOURCE_INFO_LIST:
+						w.u32(56)
+						w.str("alsa.monitor")
+						w.str("Monitor of Built-in Audio")
+						w.sampleSpec(3, 2, 48000)
+						w.channelMap([]byte{1, 2})
+						w.u32(PA_INVALID_INDEX)
+						w.buf = append(w.buf, PA_TAG_CVOLUME, 2, 0, 1, 0, 0, 0, 1, 0, 0)
+						w.boolean(false)
+						w.u32(0)
+						w.str("alsa")
+						w.buf = append(w.buf, PA_TAG_USEC, 0, 0, 0, 0, 0, 0, 0, 0)
+						w.str("PipeWire")
+						w.u32(0)
+						w.proplist(map[string]string{"device.class": "monitor"})
+						w.buf = append(w.buf, PA_TAG_USEC, 0, 0, 0, 0, 0, 0, 0, 0)
+					case PA_COMMAND_LOAD_MODULE:
+						if r.str() != "module-null-sink" || r.str() != "sink_name=blast" {
+							t.Error("bad load module arguments")
+						}
+						w.u32(536870913)
+					case PA_COMMAND_UNLOAD_MODULE:
+					case PA_COMMAND_CREATE_RECORD_STREAM:
+						w.u32(3)
+						w.u32(9)
+						c.writePacket(PA_COMMAND_CHANNEL, w.buf)
+						c.writePacket(3, []byte("pcm"))
+						c.writePacket(3, []byte("data"))
+						continue
+					default:
+						w = &tagWriter{}
+						w.u32(PA_COMMAND_ERROR)
+						w.u32(tag)
+						w.u32(1)
+					}
+					c.writePacket(PA_COMMAND_CHANNEL, w.buf)
+				}
+			}()
+		}
+	}()
+}
+
+func TestPulseNative(t *testing.T) {
+	fakePulseServer(t)
+	native := pulseNative{}
+
+	sources, err := native.listSources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 1 || sources[0].Name != "alsa.monitor" ||
+		sources[0].Index != 56 || sources[0].SampleSpec != "s16le 2ch 48000Hz" {
+		t.Fatalf("unexpected sources %+v", sources)
+	}
+
+	sink, err := native.monitorSink("alsa.monitor")
+	if err != nil || sink != 0 {
+		t.Fatalf("got monitored sink %d %v", sink, err)
+	}
+	if _, err := native.monitorSink("mic"); err == nil {
+		t.Fatal("mic: no error")
+	}
+
+	id, err := native.loadModule("module-null-sink", "sink_name=blast")
+	if err != nil || id != "536870913" {
+		t.Fatalf("got module %s %v", id, err)
+	}
+	if err := native.unloadModule(id); err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err := native.record("alsa.monitor", sampleSpec{"s16le", 44100, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 7)
+	if _, err := io.ReadFull(rec, data); err != nil || string(data) != "pcmdata" {
+		t.Fatalf("got %q %v", data, err)
+	}
+	rec.Close()
+}
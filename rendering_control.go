@@ -0,0 +1,126 @@
+// MIT+NoAI License
+//
+// Copyright (c) 2023 ugjka <ugjka@proton.me>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights///
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// This code may not be used to train artificial intelligence computer models
+// or retrieved by artificial intelligence software or hardware.
+package main
+
+import (
+	"fmt"
+
+	"github.com/huin/goupnp"
+	"github.com/huin/goupnp/dcps/av1"
+)
+
+const VOLUME_STEP = 5
+
+type renderingcontrol interface {
+	GetVolume(InstanceID uint32, Channel string) (CurrentVolume uint16, err error)
+	SetVolume(InstanceID uint32, Channel string, DesiredVolume uint16) (err error)
+	GetMute(InstanceID uint32, Channel string) (CurrentMute bool, err error)
+	SetMute(InstanceID uint32, Channel string, DesiredMute bool) (err error)
+}
+
+func detectRenderingControl(dev *goupnp.MaybeRootDevice) string {
+	control := dev.Root.Device.FindService(av1.URN_RenderingControl_1)
+	if len(control) > 0 {
+		return av1.URN_RenderingControl_1
+	}
+	control = dev.Root.Device.FindService(av1.URN_RenderingControl_2)
+	if len(control) > 0 {
+		return av1.URN_RenderingControl_2
+	}
+	return ""
+}
+
+func newRenderingControlClient(dev *goupnp.MaybeRootDevice) (renderingcontrol, error) {
+	urn := detectRenderingControl(dev)
+
+	switch {
+	case urn == av1.URN_RenderingControl_1:
+		clients, err := av1.NewRenderingControl1ClientsByURL(dev.Location)
+		if err != nil {
+			return nil, err
+		}
+		return renderingcontrol(clients[0]), nil
+	case urn == av1.URN_RenderingControl_2:
+		clients, err := av1.NewRenderingControl2ClientsByURL(dev.Location)
+		if err != nil {
+			return nil, err
+		}
+		return renderingcontrol(clients[0]), nil
+	}
+	return nil, fmt.Errorf("no renderingcontrol found")
+}
+
+func RCSetVolume(dev *goupnp.MaybeRootDevice, volume int) error {
+	client, err := newRenderingControlClient(dev)
+	if err != nil {
+		return err
+	}
+	return client.SetVolume(0, "Master", clampVolume(volume))
+}
+
+// RCChangeVolume changes the volume by delta and returns the new volume
+func RCChangeVolume(dev *goupnp.MaybeRootDevice, delta int) (uint16, error) {
+	client, err := newRenderingControlClient(dev)
+	if err != nil {
+		return 0, err
+	}
+	current, err := client.GetVolume(0, "Master")
+	if err != nil {
+		return 0, fmt.Errorf("get volume: %v", err)
+	}
+	volume := clampVolume(int(current) + delta)
+	err = client.SetVolume(0, "Master", volume)
+	if err != nil {
+		return 0, fmt.Errorf("set volume: %v", err)
+	}
+	return volume, nil
+}
+
+// RCToggleMute flips the mute state and returns the new state
+func RCToggleMute(dev *goupnp.MaybeRootDevice) (bool, error) {
+	client, err := newRenderingControlClient(dev)
+	if err != nil {
+		return false, err
+	}
+	muted, err := client.GetMute(0, "Master")
+	if err != nil {
+		return false, fmt.Errorf("get mute: %v", err)
+	}
+	err = client.SetMute(0, "Master", !muted)
+	if err != nil {
+		return false, fmt.Errorf("set mute: %v", err)
+	}
+	return !muted, nil
+}
+
+func clampVolume(volume int) uint16 {
+	if volume < 0 {
+		return 0
+	}
+	if volume > 100 {
+		return 100
+	}
+	return uint16(volume)
+}
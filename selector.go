@@ -28,6 +28,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 )
 
 func selector[slice any](s []slice) int {
@@ -50,3 +51,55 @@ func selector[slice any](s []slice) int {
 	fmt.Printf("[%d]\n", choice)
 	return choice
 }
+
+// multiSelector is like selector but accepts several comma or space separated
+// choices on a single line
+func multiSelector[slice any](s []slice) []int {
+	var choices []int
+	for {
+		line, err := readLine()
+		if err != nil {
+			fmt.Print("\033[1A\033[K")
+			continue
+		}
+		choices = choices[:0]
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ' '
+		})
+		valid := len(fields) > 0
+		seen := make(map[int]bool)
+		for _, field := range fields {
+			choice, err := strconv.Atoi(field)
+			if err != nil || choice < 0 || choice >= len(s) {
+				valid = false
+				break
+			}
+			if !seen[choice] {
+				seen[choice] = true
+				choices = append(choices, choice)
+			}
+		}
+		fmt.Print("\033[1A\033[K")
+		if valid {
+			break
+		}
+	}
+	fmt.Printf("%v\n", choices)
+	return choices
+}
+
+func readLine() (string, error) {
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		_, err := os.Stdin.Read(buf)
+		if err != nil {
+			return "", err
+		}
+		if buf[0] == '\n' {
+			break
+		}
+		line = append(line, buf[0])
+	}
+	return strings.TrimSpace(string(line)), nil
+}
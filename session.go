@@ -0,0 +1,608 @@
+// MIT+NoAI License
+//
+// Copyright (c) 2023 ugjka <ugjka@proton.me>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights///
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// This code may not be used to train artificial intelligence computer models
+// or retrieved by artificial intelligence software or hardware.
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/huin/goupnp"
+)
+
+// session is the state of a running blast instance,
+// it is shared between main, the hotkeys and the control api
+type session struct {
+	mu sync.Mutex
+	// serializes playing, switching and restarting, which talk to
+	// the renderers for a while without holding mu
+	op      sync.Mutex
+	devices []*goupnp.MaybeRootDevice
+	source  string
+	// the formats to try in order
+	streams []stream
+	// what each device is playing
+	active  map[*goupnp.MaybeRootDevice]avsetup
+	baseURI string
+	logoURI string
+	// how long to wait for the renderer to request a stream
+	timeout time.Duration
+	volume  int
+	// overrides the stream uri protocol
+	protocol string
+	watchdog bool
+	// now playing info of the source
+	meta *metadata
+	// the renderers are let in to the streams
+	access *accessList
+	// set the uri again when the metadata changes
+	metadataReset bool
+	playing       bool
+	// bumped on every stop, so that a device that was still
+	// trying formats doesn't play after it
+	gen     int
+	started time.Time
+	// closed when a device is stopped, ends its watchdog
+	quit        map[*goupnp.MaybeRootDevice]chan struct{}
+	blastSinkID string
+	// renderer events are sent to this uri plus the subscription id,
+	// empty if they aren't subscribed to
+	eventsURI string
+	evmu      sync.Mutex
+	subs      map[string]*subscription
+	states    map[*goupnp.MaybeRootDevice]*rendererState
+	nextSub   int
+	// how long a renderer has to stay stopped to count as stopped,
+	// setting the uri again passes through STOPPED too
+	stopAfter time.Duration
+	// called when the renderers stopped playing by themselves
+	onStop func()
+	// watches the network for the renderers, nil unless -daemon
+	daemon *daemon
+	cfg    *config
+	// flags given on the command line
+	given map[string]bool
+}
+
+// play sets the avtransport uri on all the devices and starts playing
+func (s *session) play() error {
+	s.op.Lock()
+	defer s.op.Unlock()
+	return s.playDevices()
+}
+
+// playDevices plays on every device at once, the caller holds s.op.
+// Trying the formats takes a while, so s.mu is only held to record
+// the devices that play.
+func (s *session) playDevices() error {
+	s.mu.Lock()
+	devices := s.devices
+	if len(devices) == 0 {
+		s.mu.Unlock()
+		return nil
+	}
+	log.Println("setting avtransport URI and playing")
+	if s.access != nil {
+		s.access.setRenderers(devices)
+	}
+	s.quit = make(map[*goupnp.MaybeRootDevice]chan struct{})
+	s.active = make(map[*goupnp.MaybeRootDevice]avsetup)
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, dev := range devices {
+		wg.Add(1)
+		go func(dev *goupnp.MaybeRootDevice) {
+			defer wg.Done()
+			err := s.startDevice(dev)
+			if err != nil {
+				log.Printf("transport: %s: %v", deviceName(dev), err)
+			}
+		}(dev)
+	}
+	wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.active) == 0 {
+		return fmt.Errorf("none of the devices are playing")
+	}
+	return nil
+}
+
+// startDevice plays the stream on the device and starts watching it,
+// without holding s.mu
+func (s *session) startDevice(dev *goupnp.MaybeRootDevice) error {
+	s.mu.Lock()
+	gen := s.gen
+	s.mu.Unlock()
+	av, err := s.playDevice(dev)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if gen != s.gen {
+		// stopped while the formats were tried
+		s.mu.Unlock()
+		AVStop(dev)
+		return fmt.Errorf("stopped before it played")
+	}
+	quit := make(chan struct{})
+	s.quit[dev] = quit
+	s.active[dev] = av
+	s.playing = true
+	s.mu.Unlock()
+	s.subscribeEvents(av, quit)
+	s.mu.Lock()
+	stopped := gen != s.gen
+	s.mu.Unlock()
+	if stopped {
+		// stopped while subscribing
+		s.unsubscribeEvents(dev)
+	}
+	volume, _ := s.deviceOptions(dev)
+	if volume >= 0 {
+		err = RCSetVolume(dev, volume)
+		if err != nil {
+			log.Printf("volume: %s: %v", deviceName(dev), err)
+		}
+	}
+	if s.watchdog {
+		go watchdog(av, quit)
+	}
+	return nil
+}
+
+// dropLocked forgets what the device is playing without stopping it,
+// the caller cancels the returned subscriptions after unlocking s.mu
+func (s *session) dropLocked(dev *goupnp.MaybeRootDevice) []*subscription {
+	if _, ok := s.active[dev]; !ok {
+		return nil
+	}
+	delete(s.active, dev)
+	close(s.quit[dev])
+	delete(s.quit, dev)
+	return s.takeSubscriptions(dev)
+}
+
+// idleLocked stops the streams once no renderer is playing
+func (s *session) idleLocked() {
+	log.Println("no renderer is playing, stopping the stream")
+	s.playing = false
+	for _, st := range s.streams {
+		st.pipe.stop()
+	}
+}
+
+// addDevice plays on a renderer that showed up on the network,
+// it replaces an earlier instance of the same device
+func (s *session) addDevice(dev *goupnp.MaybeRootDevice) error {
+	s.op.Lock()
+	defer s.op.Unlock()
+	s.mu.Lock()
+	subs, _ := s.removeLocked(dev.Root.Device.UDN)
+	s.devices = append(s.devices, dev)
+	if s.access != nil {
+		s.access.setRenderers(s.devices)
+	}
+	if !s.playing {
+		s.quit = make(map[*goupnp.MaybeRootDevice]chan struct{})
+		s.active = make(map[*goupnp.MaybeRootDevice]avsetup)
+	}
+	s.mu.Unlock()
+	go cancelSubscriptions(subs)
+	return s.startDevice(dev)
+}
+
+// removeDevice forgets a renderer that left the network
+func (s *session) removeDevice(udn string) {
+	s.mu.Lock()
+	subs, removed := s.removeLocked(udn)
+	if removed {
+		if s.access != nil {
+			s.access.setRenderers(s.devices)
+		}
+		if s.playing && len(s.active) == 0 {
+			s.idleLocked()
+		}
+	}
+	s.mu.Unlock()
+	// the renderer is gone, don't keep the daemon waiting for it
+	go cancelSubscriptions(subs)
+}
+
+// removeLocked forgets the device, the caller cancels
+// the returned subscriptions after unlocking s.mu
+func (s *session) removeLocked(udn string) ([]*subscription, bool) {
+	var removed bool
+	var subs []*subscription
+	var devices []*goupnp.MaybeRootDevice
+	for _, dev := range s.devices {
+		if dev.Root != nil && strings.EqualFold(dev.Root.Device.UDN, udn) {
+			subs = append(subs, s.dropLocked(dev)...)
+			removed = true
+			continue
+		}
+		devices = append(devices, dev)
+	}
+	s.devices = devices
+	return subs, removed
+}
+
+// playDevice tries the formats in order until the device plays one
+func (s *session) playDevice(dev *goupnp.MaybeRootDevice) (avsetup, error) {
+	host := lookupHost(dev.Location.Hostname())
+	_, protocol := s.deviceOptions(dev)
+	var err error
+	for i, st := range s.streams {
+		av := avsetup{
+			device:    dev,
+			stream:    st,
+			logoURI:   s.logoURI,
+			streamURI: s.baseURI + "/" + st.path,
+			now:       s.meta.get(),
+		}
+		switch {
+		case protocol != "" && protocol != "http":
+			av.streamURI = withScheme(av.streamURI, protocol)
+		case protocol == "" && st.format == "mp3" && detectSonos(dev):
+			av.streamURI = withScheme(av.streamURI, "x-rincon-mp3radio")
+		}
+		last := i == len(s.streams)-1
+		requests := st.pipe.requestsFrom(host)
+		err = AVSetAndPlay(av)
+		if err == nil && !last {
+			err = waitForRequest(st.pipe, host, requests, s.timeout)
+			if err != nil {
+				AVStop(dev)
+			}
+		}
+		if err == nil {
+			if len(s.streams) > 1 {
+				log.Printf("%s: playing %s (%s)", deviceName(dev), st.path, st.mime)
+			}
+			return av, nil
+		}
+		if !last {
+			log.Printf("%s: %s failed: %v, trying the next format",
+				deviceName(dev), st.path, err)
+		}
+	}
+	return avsetup{}, err
+}
+
+// withScheme replaces the scheme of the uri
+func withScheme(uri, scheme string) string {
+	_, rest, _ := strings.Cut(uri, "://")
+	return scheme + "://" + rest
+}
+
+// deviceOptions returns the volume and protocol for the device,
+// its profile wins over the config defaults but not over the flags
+func (s *session) deviceOptions(dev *goupnp.MaybeRootDevice) (volume int, protocol string) {
+	volume, protocol = s.volume, s.protocol
+	if s.cfg == nil {
+		return
+	}
+	_, profile, ok := s.cfg.profile(dev)
+	if !ok {
+		return
+	}
+	if v, ok := profile["volume"]; ok && !s.given["volume"] {
+		if n, err := strconv.Atoi(fmt.Sprint(v)); err == nil {
+			volume = n
+		}
+	}
+	if p, ok := profile["protocol"]; ok && !s.given["protocol"] {
+		protocol = fmt.Sprint(p)
+	}
+	return
+}
+
+// waitForRequest waits for the renderer to fetch the stream
+func waitForRequest(pipe *pipeline, host string, before int, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if pipe.requestsFrom(host) > before {
+			return nil
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+	return fmt.Errorf("the stream wasn't requested in %s", timeout)
+}
+
+// stop stops the avtransport on all the devices
+func (s *session) stop() {
+	s.mu.Lock()
+	stopRenderers := s.stopLocked()
+	s.mu.Unlock()
+	stopRenderers()
+}
+
+// stopLocked stops the session, the caller runs the returned func
+// after unlocking s.mu, a renderer that is gone takes long to fail
+func (s *session) stopLocked() func() {
+	// the devices still trying formats stop too
+	s.gen++
+	if !s.playing {
+		return func() {}
+	}
+	for _, quit := range s.quit {
+		close(quit)
+	}
+	subs := s.takeSubscriptions(nil)
+	devices := s.devices
+	s.playing = false
+	return func() {
+		cancelSubscriptions(subs)
+		var wg sync.WaitGroup
+		for _, dev := range devices {
+			wg.Add(1)
+			go func(dev *goupnp.MaybeRootDevice) {
+				defer wg.Done()
+				AVStop(dev)
+			}(dev)
+		}
+		wg.Wait()
+	}
+}
+
+func (s *session) restart() error {
+	s.op.Lock()
+	defer s.op.Unlock()
+	s.stop()
+	return s.playDevices()
+}
+
+// switchDevices stops the current devices and plays on the new ones
+func (s *session) switchDevices(devices []*goupnp.MaybeRootDevice) error {
+	s.op.Lock()
+	defer s.op.Unlock()
+	s.mu.Lock()
+	stopRenderers := s.stopLocked()
+	s.devices = devices
+	s.mu.Unlock()
+	stopRenderers()
+	return s.playDevices()
+}
+
+// useSource loads the blast sink when it's needed
+func (s *session) useSource(source string) error {
+	if source == BLASTMONITOR && s.blastSinkID == "" {
+		id, err := pulse.loadModule("module-null-sink", "sink_name=blast")
+		if err != nil {
+			return fmt.Errorf("blast sink: %v", err)
+		}
+		s.blastSinkID = id
+	}
+	s.source = source
+	return nil
+}
+
+// switchSource restarts the pipeline with the new audio source
+func (s *session) switchSource(source string) error {
+	s.op.Lock()
+	defer s.op.Unlock()
+	s.mu.Lock()
+	err := s.useSource(source)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	for _, st := range s.streams {
+		st.pipe.setSink(source)
+	}
+	stopRenderers := s.stopLocked()
+	s.mu.Unlock()
+	stopRenderers()
+	return s.playDevices()
+}
+
+func (s *session) unloadBlastSink() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.blastSinkID != "" {
+		log.Println("unloading the blast sink")
+		pulse.unloadModule(s.blastSinkID)
+		s.blastSinkID = ""
+	}
+}
+
+// updateMetadata sends the new now playing info to the playing devices,
+// outside the lock since a renderer that is gone takes long to fail
+func (s *session) updateMetadata(np nowPlaying) {
+	s.mu.Lock()
+	if !s.playing {
+		s.mu.Unlock()
+		return
+	}
+	active := make(map[*goupnp.MaybeRootDevice]avsetup, len(s.active))
+	for dev, av := range s.active {
+		active[dev] = av
+	}
+	reset := s.metadataReset
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for dev, av := range active {
+		wg.Add(1)
+		go func(dev *goupnp.MaybeRootDevice, av avsetup) {
+			defer wg.Done()
+			av.now = np
+			err := AVSetMetadata(av, reset)
+			if err != nil {
+				log.Printf("metadata: %s: %v", deviceName(dev), err)
+				return
+			}
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			// unless the device was stopped or played again meanwhile
+			if cur, ok := s.active[dev]; ok && cur.streamURI == av.streamURI {
+				cur.now = np
+				s.active[dev] = cur
+			}
+		}(dev, av)
+	}
+	wg.Wait()
+}
+
+// setTitle pins the title on the renderers and the icy streams
+func (s *session) setTitle(np nowPlaying) {
+	s.meta.pin(np)
+	if np.Title != "" {
+		log.Printf("title: %s", np.streamTitle())
+	}
+	s.updateMetadata(np)
+}
+
+// forEachDevice runs fn on a snapshot of the current devices
+func (s *session) forEachDevice(fn func(dev *goupnp.MaybeRootDevice)) {
+	s.mu.Lock()
+	devices := s.devices
+	s.mu.Unlock()
+	for _, dev := range devices {
+		fn(dev)
+	}
+}
+
+func (s *session) setVolume(volume int) {
+	s.forEachDevice(func(dev *goupnp.MaybeRootDevice) {
+		err := RCSetVolume(dev, volume)
+		if err != nil {
+			log.Printf("volume: %s: %v", deviceName(dev), err)
+			return
+		}
+		log.Printf("volume: %s: %d", deviceName(dev), clampVolume(volume))
+	})
+}
+
+func (s *session) changeVolume(delta int) {
+	s.forEachDevice(func(dev *goupnp.MaybeRootDevice) {
+		volume, err := RCChangeVolume(dev, delta)
+		if err != nil {
+			log.Printf("volume: %s: %v", deviceName(dev), err)
+			return
+		}
+		log.Printf("volume: %s: %d", deviceName(dev), volume)
+	})
+}
+
+func (s *session) toggleMute() {
+	s.forEachDevice(func(dev *goupnp.MaybeRootDevice) {
+		muted, err := RCToggleMute(dev)
+		if err != nil {
+			log.Printf("mute: %s: %v", deviceName(dev), err)
+			return
+		}
+		log.Printf("mute: %s: %v", deviceName(dev), muted)
+	})
+}
+
+type sessionStatus struct {
+	Devices []deviceStatus `json:"devices"`
+	Source  string         `json:"source"`
+	Formats []string       `json:"formats"`
+	Streams []string       `json:"streams"`
+	Playing bool           `json:"playing"`
+	Clients int            `json:"clients"`
+	Now     nowPlaying     `json:"now_playing"`
+	Uptime  string         `json:"uptime"`
+	// the renderers on the network in daemon mode
+	Network []string `json:"network,omitempty"`
+}
+
+type deviceStatus struct {
+	Name      string `json:"name"`
+	Format    string `json:"format,omitempty"`
+	Mime      string `json:"mime,omitempty"`
+	StreamURI string `json:"stream_uri,omitempty"`
+	// from the renderer's events
+	Renderer *rendererState `json:"renderer,omitempty"`
+}
+
+func (s *session) status() sessionStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := sessionStatus{
+		Devices: []deviceStatus{},
+		Formats: []string{},
+		Streams: []string{},
+		Source:  s.source,
+		Playing: s.playing,
+		Now:     s.meta.get(),
+		Uptime:  time.Since(s.started).Round(time.Second).String(),
+	}
+	for _, st := range s.streams {
+		status.Formats = append(status.Formats, st.format)
+		status.Streams = append(status.Streams, s.baseURI+"/"+st.path)
+		status.Clients += st.pipe.connected()
+	}
+	for _, dev := range s.devices {
+		device := deviceStatus{Name: deviceName(dev)}
+		if av, ok := s.active[dev]; ok {
+			device.Format = av.stream.format
+			device.Mime = av.stream.mime
+			device.StreamURI = av.streamURI
+		}
+		if st, ok := s.rendererState(dev); ok {
+			device.Renderer = &st
+		}
+		status.Devices = append(status.Devices, device)
+	}
+	if s.daemon != nil {
+		status.Network = s.daemon.list()
+	}
+	return status
+}
+
+// casting reports whether the device is playing and pulling the stream
+func (s *session) casting(dev *goupnp.MaybeRootDevice) bool {
+	s.mu.Lock()
+	var active bool
+	for d := range s.active {
+		if d.Root != nil && dev.Root != nil &&
+			strings.EqualFold(d.Root.Device.UDN, dev.Root.Device.UDN) {
+			active = true
+		}
+	}
+	s.mu.Unlock()
+	return active && s.streaming(dev)
+}
+
+// streaming reports whether the device is pulling any of the streams
+func (s *session) streaming(dev *goupnp.MaybeRootDevice) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	host := lookupHost(dev.Location.Hostname())
+	for _, st := range s.streams {
+		if st.pipe.connectedFrom(host) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"crypto/x509"
+	"net"
+	"testing"
+)
+
+func TestSelfSignedCert(t *testing.T) {
+	host := net.ParseIP("192.168.1.5")
+	cert, err := selfSignedCert(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parsed.VerifyHostname(host.String()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,90 @@
+// MIT+NoAI License
+//
+// Copyright (c) 2023 ugjka <ugjka@proton.me>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights///
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// This code may not be used to train artificial intelligence computer models
+// or retrieved by artificial intelligence software or hardware.
+package main
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	WATCHDOG_INTERVAL    = 5 * time.Second
+	WATCHDOG_MIN_BACKOFF = 5 * time.Second
+	WATCHDOG_MAX_BACKOFF = 5 * time.Minute
+)
+
+// watchdog re-plays the stream on a renderer that stopped pulling it,
+// e.g. after a network blip or when the renderer went to sleep
+func watchdog(av avsetup, quit <-chan struct{}) {
+	name := deviceName(av.device)
+	host := lookupHost(av.device.Location.Hostname())
+	backoff := WATCHDOG_MIN_BACKOFF
+	var retry time.Time
+	wait := func() {
+		retry = time.Now().Add(backoff)
+		backoff *= 2
+		if backoff > WATCHDOG_MAX_BACKOFF {
+			backoff = WATCHDOG_MAX_BACKOFF
+		}
+	}
+
+	for {
+		select {
+		case <-quit:
+			return
+		case <-time.After(WATCHDOG_INTERVAL):
+		}
+		if av.stream.pipe.connectedFrom(host) > 0 {
+			backoff = WATCHDOG_MIN_BACKOFF
+			continue
+		}
+		if time.Now().Before(retry) {
+			continue
+		}
+		client, err := newAVTransportClient(av.device)
+		if err != nil {
+			log.Printf("watchdog: %s: %v", name, err)
+			wait()
+			continue
+		}
+		state, _, _, err := client.GetTransportInfo(0)
+		if err != nil {
+			log.Printf("watchdog: %s: transport info: %v", name, err)
+			wait()
+			continue
+		}
+		if state != "STOPPED" && state != "NO_MEDIA_PRESENT" {
+			continue
+		}
+		log.Printf("watchdog: %s is %s, playing again", name, state)
+		err = AVSetAndPlay(av)
+		if err != nil {
+			log.Printf("watchdog: %s: %v (retrying in %s)", name, err, backoff)
+		}
+		// give the renderer time to connect,
+		// back off if it keeps failing
+		wait()
+	}
+}